@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"time"
 )
 
+// Default base URL of upstream API
 var hostAddr = string([]byte{0x68, 0x74, 0x74, 0x70, 0x73, 0x3a, 0x2f, 0x2f, 0x66, 0x6f, 0x6f, 0x74, 0x62, 0x61, 0x6c, 0x6c, 0x33, 0x36, 0x30, 0x2e, 0x69, 0x72})
 
 // Searches with result filtering
@@ -20,6 +22,11 @@ var hostAddr = string([]byte{0x68, 0x74, 0x74, 0x70, 0x73, 0x3a, 0x2f, 0x2f, 0x6
 //   - offset: result offset
 //   - limit: result limit ( default 10 )
 func (cli *Session) AdvancedSearch(q string, filter uint8, offset, limit uint16) (AdvancedSuggestsInterface, error) {
+	return cli.AdvancedSearchContext(context.Background(), q, filter, offset, limit)
+}
+
+// AdvancedSearchContext is like AdvancedSearch, but the request is canceled when ctx is done.
+func (cli *Session) AdvancedSearchContext(ctx context.Context, q string, filter uint8, offset, limit uint16) (AdvancedSuggestsInterface, error) {
 	if len(q) < 4 {
 		return nil, errors.New("query is too short: len(q) < 4")
 	}
@@ -58,10 +65,12 @@ func (cli *Session) AdvancedSearch(q string, filter uint8, offset, limit uint16)
 		fmt.Printf("(*Client).AdvancedSearch: Warning: unknown filter: %d, It is setting 0 automatically.\n", filter)
 	}
 
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + fmt.Sprintf("/api/search/%s/?q=%s&offset=%d&limit=%d", filter_q, q, offset, limit),
-			Referer: hostAddr + "/search/", CloseConnection: true,
+			Method: "GET", URI: cli.endpoint(fmt.Sprintf("/api/search/%s/?q=%s&offset=%d&limit=%d", filter_q, q, offset, limit)),
+			Referer: cli.baseURL + "/search/", CloseConnection: true,
+			Family: FAMILY_SEARCH,
 		},
 		&ret,
 	)
@@ -77,15 +86,22 @@ func (cli *Session) AdvancedSearch(q string, filter uint8, offset, limit uint16)
 // Parameters:
 //   - current_id: competition current id.
 func (cli *Session) GetCompetitionStandingTable(current_id string) (StandingTable, error) {
+	return cli.GetCompetitionStandingTableContext(context.Background(), current_id)
+}
+
+// GetCompetitionStandingTableContext is like GetCompetitionStandingTable, but the request is canceled when ctx is done.
+func (cli *Session) GetCompetitionStandingTableContext(ctx context.Context, current_id string) (StandingTable, error) {
 	if current_id == "" {
 		return nil, errors.New("current_id is empty")
 	}
 
 	var obj StandingTable
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/standing-table/" + current_id + "/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/standing-table/" + current_id + "/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_COMPETITION,
 		},
 		&obj,
 	)
@@ -97,15 +113,22 @@ func (cli *Session) GetCompetitionStandingTable(current_id string) (StandingTabl
 // Parameters:
 //   - current_id: competition current id.
 func (cli *Session) GetCompetitionWeeks(current_id string) (*CompetitionWeeks, error) {
+	return cli.GetCompetitionWeeksContext(context.Background(), current_id)
+}
+
+// GetCompetitionWeeksContext is like GetCompetitionWeeks, but the request is canceled when ctx is done.
+func (cli *Session) GetCompetitionWeeksContext(ctx context.Context, current_id string) (*CompetitionWeeks, error) {
 	if current_id == "" {
 		return nil, errors.New("current_id is empty")
 	}
 
 	var obj CompetitionWeeks
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/competition-trends/" + current_id + "/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/competition-trends/" + current_id + "/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_COMPETITION,
 		},
 		&obj,
 	)
@@ -121,6 +144,11 @@ func (cli *Session) GetCompetitionWeeks(current_id string) (*CompetitionWeeks, e
 // Parameters:
 //   - c_type: competition type, (C)lub competitions or (N)ational competitions ( can be zero ).
 func (cli *Session) GetCompetitionsList(c_type string) (CompetitionsList, error) {
+	return cli.GetCompetitionsListContext(context.Background(), c_type)
+}
+
+// GetCompetitionsListContext is like GetCompetitionsList, but the request is canceled when ctx is done.
+func (cli *Session) GetCompetitionsListContext(ctx context.Context, c_type string) (CompetitionsList, error) {
 	var c_type_q string
 	if c_type != "" {
 		c_type_q = "&type=" + c_type
@@ -128,10 +156,12 @@ func (cli *Session) GetCompetitionsList(c_type string) (CompetitionsList, error)
 
 	var rawobj map[string]json.RawMessage
 
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/base/competitions/defaults/" + c_type_q,
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/base/competitions/defaults/" + c_type_q),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_COMPETITION,
 		},
 		&rawobj,
 	)
@@ -155,11 +185,18 @@ func (cli *Session) GetCompetitionsList(c_type string) (CompetitionsList, error)
 // Parameters:
 //   - match_id: the match id.
 func (cli *Session) GetMatchInfo(match_id string) (*MatchInfo, error) {
+	return cli.GetMatchInfoContext(context.Background(), match_id)
+}
+
+// GetMatchInfoContext is like GetMatchInfo, but the request is canceled when ctx is done.
+func (cli *Session) GetMatchInfoContext(ctx context.Context, match_id string) (*MatchInfo, error) {
 	var obj MatchInfo
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/base/v2/matches/" + match_id + "/info/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/base/v2/matches/" + match_id + "/info/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_MATCH,
 		},
 		&obj,
 	)
@@ -176,6 +213,11 @@ func (cli *Session) GetMatchInfo(match_id string) (*MatchInfo, error) {
 //   - days: days after today ( can be zero or negative ).
 //   - slugs: the slugs of competitions that matches you want.
 func (cli *Session) GetMatchesByDate(days int, slugs ...string) (CompetitionMatches, error) {
+	return cli.GetMatchesByDateContext(context.Background(), days, slugs...)
+}
+
+// GetMatchesByDateContext is like GetMatchesByDate, but the request is canceled when ctx is done.
+func (cli *Session) GetMatchesByDateContext(ctx context.Context, days int, slugs ...string) (CompetitionMatches, error) {
 	var slug_q string
 	if len(slugs) > 0 {
 		slug_q = "&slugs=" + url.QueryEscape(strings.Join(slugs, ","))
@@ -187,10 +229,12 @@ func (cli *Session) GetMatchesByDate(days int, slugs ...string) (CompetitionMatc
 	}
 
 	var obj CompetitionMatches = nil
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/competition-trends/matches-by-date/?date=" + date.Format("2006-01-02") + slug_q,
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/competition-trends/matches-by-date/?date=" + date.Format("2006-01-02") + slug_q),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_MATCH,
 		},
 		&obj,
 	)
@@ -204,15 +248,22 @@ func (cli *Session) GetMatchesByDate(days int, slugs ...string) (CompetitionMatc
 //   - week_number: week number.
 //   - current_id: competition current id.
 func (cli *Session) GetMatchesByWeekNumber(current_id string, week_number uint32) ([]MatchBase, error) {
+	return cli.GetMatchesByWeekNumberContext(context.Background(), current_id, week_number)
+}
+
+// GetMatchesByWeekNumberContext is like GetMatchesByWeekNumber, but the request is canceled when ctx is done.
+func (cli *Session) GetMatchesByWeekNumberContext(ctx context.Context, current_id string, week_number uint32) ([]MatchBase, error) {
 	if current_id == "" {
 		return nil, errors.New("current_id is empty")
 	}
 
 	var obj []MatchBase = nil
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/competition-trends/" + current_id + "/weeks/" + strconv.Itoa(int(week_number)) + "/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/competition-trends/" + current_id + "/weeks/" + strconv.Itoa(int(week_number)) + "/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_MATCH,
 		},
 		&obj,
 	)
@@ -225,16 +276,23 @@ func (cli *Session) GetMatchesByWeekNumber(current_id string, week_number uint32
 // Parameters:
 //   - season_id: season id.
 func (cli *Session) GetTransfers(season_id string) (Transfers, error) {
+	return cli.GetTransfersContext(context.Background(), season_id)
+}
+
+// GetTransfersContext is like GetTransfers, but the request is canceled when ctx is done.
+func (cli *Session) GetTransfersContext(ctx context.Context, season_id string) (Transfers, error) {
 	if season_id == "" {
 		return nil, errors.New("season_id is empty")
 	}
 
 	var rawobj map[string]json.RawMessage
 
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/transfers/transfer-seasons/" + season_id + "/transfers/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/transfers/transfer-seasons/" + season_id + "/transfers/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_TRANSFERS,
 		},
 		&rawobj,
 	)
@@ -255,12 +313,19 @@ func (cli *Session) GetTransfers(season_id string) (Transfers, error) {
 
 // Returns the regions that have transfer.
 func (cli *Session) GetTransfersRegions() (*TransfersRegions, error) {
+	return cli.GetTransfersRegionsContext(context.Background())
+}
+
+// GetTransfersRegionsContext is like GetTransfersRegions, but the request is canceled when ctx is done.
+func (cli *Session) GetTransfersRegionsContext(ctx context.Context) (*TransfersRegions, error) {
 	var obj TransfersRegions
 
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/transfers/regions/",
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/transfers/regions/"),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_TRANSFERS,
 		},
 		&obj,
 	)
@@ -280,6 +345,11 @@ func (cli *Session) GetTransfersRegions() (*TransfersRegions, error) {
 // - Simple search: coaches, players, teams.
 // - Full search: coaches, players, teams, competitions, news.
 func (cli *Session) Search(q string, s_type uint8) (*Suggests, error) {
+	return cli.SearchContext(context.Background(), q, s_type)
+}
+
+// SearchContext is like Search, but the request is canceled when ctx is done.
+func (cli *Session) SearchContext(ctx context.Context, q string, s_type uint8) (*Suggests, error) {
 	if len(q) < 4 {
 		return nil, errors.New("query is too short: len(q) < 4")
 	}
@@ -298,10 +368,12 @@ func (cli *Session) Search(q string, s_type uint8) (*Suggests, error) {
 	}
 
 	var obj Suggests
-	err := cli.RequestJSON(
+	err := cli.RequestJSONContext(
+		ctx,
 		RequestConfig{
-			Method: "GET", URI: hostAddr + "/api/search/suggest/?q=" + q + "&location=" + s_type_q,
-			Referer: hostAddr, CloseConnection: true,
+			Method: "GET", URI: cli.endpoint("/api/search/suggest/?q=" + q + "&location=" + s_type_q),
+			Referer: cli.baseURL, CloseConnection: true,
+			Family: FAMILY_SEARCH,
 		},
 		&obj,
 	)
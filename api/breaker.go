@@ -0,0 +1,220 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/awolverp/kickcore/logging"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Endpoint families of upstream API; each family has its own circuit breaker and rate limit.
+const (
+	FAMILY_SEARCH      = "search"
+	FAMILY_MATCH       = "match"
+	FAMILY_COMPETITION = "competition"
+	FAMILY_TRANSFERS   = "transfers"
+)
+
+var families = []string{FAMILY_SEARCH, FAMILY_MATCH, FAMILY_COMPETITION, FAMILY_TRANSFERS}
+
+func isFamily(name string) bool {
+	for _, family := range families {
+		if family == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Circuit breaker states
+const (
+	// Requests are sent to upstream
+	BREAKER_CLOSED = "closed"
+
+	// Requests fail fast by 503 status code without sending to upstream
+	BREAKER_OPEN = "open"
+
+	// A single probe request is sent to upstream; other requests fail fast
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+// Circuit breaker configuration of upstream requests.
+//
+// The breaker of an endpoint family opens after Threshold consecutive failures (network errors
+// and 5xx status codes), and requests of the family fail fast by 503 *StatusCodeError. After
+// OpenTimeout, it half-opens and sends a probe request: if the probe succeeds the breaker closes,
+// otherwise it opens again.
+type BreakerConfig struct {
+	// Number of consecutive failures which opens the breaker; zero disables the breakers.
+	Threshold int
+
+	// Duration that the breaker is open before probing ( default 30s ).
+	OpenTimeout time.Duration
+}
+
+// State of a circuit breaker
+type BreakerStats struct {
+	// BREAKER_CLOSED, BREAKER_OPEN or BREAKER_HALF_OPEN
+	State string `json:"state"`
+
+	// Number of consecutive failures
+	Failures int `json:"failures"`
+
+	// Unix time that the breaker opened last time; zero if it's never opened
+	OpenedAt int64 `json:"opened_at"`
+
+	// Number of times the breaker opened
+	Opens uint64 `json:"opens"`
+
+	// Number of requests which failed fast
+	Rejected uint64 `json:"rejected"`
+}
+
+type breaker struct {
+	locker sync.Mutex
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	opens, rejected uint64
+}
+
+type breakers struct {
+	config BreakerConfig
+	family map[string]*breaker
+}
+
+func newBreakers(c BreakerConfig) *breakers {
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = time.Second * 30
+	}
+
+	b := &breakers{config: c, family: make(map[string]*breaker, len(families))}
+	for _, name := range families {
+		b.family[name] = &breaker{state: BREAKER_CLOSED}
+	}
+	return b
+}
+
+// get returns the breaker of family, or nil if the breakers are disabled or family is unknown.
+func (b *breakers) get(family string) *breaker {
+	if b == nil || b.config.Threshold <= 0 {
+		return nil
+	}
+	return b.family[family]
+}
+
+// allow reports whether a request can be sent; if so, the caller must call done with the result.
+func (br *breaker) allow(c *BreakerConfig) bool {
+	br.locker.Lock()
+	defer br.locker.Unlock()
+
+	switch br.state {
+	case BREAKER_OPEN:
+		if time.Since(br.openedAt) < c.OpenTimeout {
+			br.rejected++
+			return false
+		}
+		br.state = BREAKER_HALF_OPEN
+		br.probing = true
+		return true
+
+	case BREAKER_HALF_OPEN:
+		if br.probing {
+			br.rejected++
+			return false
+		}
+		br.probing = true
+		return true
+	}
+
+	return true
+}
+
+// done records the result of a request, and returns the new state if it's changed.
+func (br *breaker) done(c *BreakerConfig, failed bool) (string, bool) {
+	br.locker.Lock()
+	defer br.locker.Unlock()
+
+	old := br.state
+
+	if !failed {
+		br.state = BREAKER_CLOSED
+		br.failures = 0
+		br.probing = false
+		return br.state, old != br.state
+	}
+
+	br.failures++
+	if br.state == BREAKER_HALF_OPEN || br.failures >= c.Threshold {
+		if br.state != BREAKER_OPEN {
+			br.opens++
+		}
+		br.state = BREAKER_OPEN
+		br.openedAt = time.Now()
+		br.probing = false
+	}
+
+	return br.state, old != br.state
+}
+
+// cancel is called instead of done if the allowed request isn't sent; so another
+// request can probe the upstream.
+func (br *breaker) cancel() {
+	br.locker.Lock()
+	br.probing = false
+	br.locker.Unlock()
+}
+
+func (br *breaker) stats() BreakerStats {
+	br.locker.Lock()
+	defer br.locker.Unlock()
+
+	s := BreakerStats{
+		State: br.state, Failures: br.failures, Opens: br.opens, Rejected: br.rejected,
+	}
+	if !br.openedAt.IsZero() {
+		s.OpenedAt = br.openedAt.Unix()
+	}
+	return s
+}
+
+// Returns the states of circuit breakers by their endpoint family (e.g. FAMILY_MATCH);
+// returns an empty map if the breakers are disabled.
+func (s *Session) BreakerStats() map[string]BreakerStats {
+	result := make(map[string]BreakerStats, len(families))
+	if s.breakers == nil || s.breakers.config.Threshold <= 0 {
+		return result
+	}
+
+	for name, br := range s.breakers.family {
+		result[name] = br.stats()
+	}
+	return result
+}
+
+// breakerError is returned when the breaker of family is open.
+func breakerError(family string) error {
+	return &StatusCodeError{
+		Code: fasthttp.StatusServiceUnavailable,
+		Msg:  "upstream " + family + " API is unavailable (circuit breaker is open)",
+	}
+}
+
+// breakerDone records the result of request in breaker of family, and logs its state changes.
+func (s *Session) breakerDone(br *breaker, family string, failed bool) {
+	state, changed := br.done(&s.breakers.config, failed)
+	if !changed {
+		return
+	}
+
+	if state == BREAKER_OPEN {
+		s.log(logging.LEVEL_WARNING, "Circuit breaker of '%s' is open for %v", family, s.breakers.config.OpenTimeout)
+	} else {
+		s.log(logging.LEVEL_WARNING, "Circuit breaker of '%s' is %s", family, state)
+	}
+}
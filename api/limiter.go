@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Rate limiter configuration of upstream requests; it protects the server against
+// being banned by upstream.
+//
+// Requests (including retries) wait for tokens of global and family token buckets, and
+// for a free slot of in-flight requests. If a request can't be sent in MaxWait, it fails
+// by 429 *StatusCodeError.
+type LimiterConfig struct {
+	// Global requests per second; zero means unlimited.
+	Rate float64
+
+	// Requests per second by endpoint family (e.g. FAMILY_MATCH); zero means unlimited.
+	FamilyRate map[string]float64
+
+	// Maximum number of concurrent in-flight requests; zero means unlimited.
+	MaxInFlight int
+
+	// Maximum duration that a request waits in queue ( default 5s ).
+	MaxWait time.Duration
+}
+
+// State of rate limiter
+type LimiterStats struct {
+	// Number of in-flight requests
+	InFlight int64 `json:"in_flight"`
+
+	// Number of requests which are waiting in queue
+	Waiting int64 `json:"waiting"`
+
+	// Number of requests which failed because they couldn't be sent in time
+	Limited uint64 `json:"limited"`
+}
+
+// Token bucket; its capacity is one second of rate (at least 1 token).
+type bucket struct {
+	locker sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns the duration to wait for it; if the duration is more
+// than maxWait, no token is taken and returns false.
+func (b *bucket) reserve(maxWait time.Duration) (time.Duration, bool) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+
+	if wait > maxWait {
+		return 0, false
+	}
+
+	b.tokens--
+	return wait, true
+}
+
+// cancel gives back a reserved token.
+func (b *bucket) cancel() {
+	b.locker.Lock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+	b.locker.Unlock()
+}
+
+type limiter struct {
+	maxWait time.Duration
+
+	global *bucket
+	family map[string]*bucket
+
+	// semaphore of in-flight requests; nil means unlimited
+	slots chan struct{}
+
+	inFlight, waiting atomic.Int64
+	limited           atomic.Uint64
+}
+
+func newLimiter(c LimiterConfig) (*limiter, error) {
+	l := &limiter{maxWait: c.MaxWait, family: make(map[string]*bucket)}
+	if l.maxWait <= 0 {
+		l.maxWait = time.Second * 5
+	}
+
+	if c.Rate < 0 || c.MaxInFlight < 0 {
+		return nil, errors.New("rate limit and max in-flight requests can't be negative")
+	}
+
+	if c.Rate > 0 {
+		l.global = newBucket(c.Rate)
+	}
+
+	for name, rate := range c.FamilyRate {
+		if !isFamily(name) {
+			return nil, errors.New("unknown endpoint family: '" + name + "'")
+		}
+		if rate < 0 {
+			return nil, errors.New("rate limit of '" + name + "' can't be negative")
+		}
+		if rate > 0 {
+			l.family[name] = newBucket(rate)
+		}
+	}
+
+	if c.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, c.MaxInFlight)
+	}
+
+	return l, nil
+}
+
+// limitedError is returned when a request can't be sent in time.
+func limitedError() error {
+	return &StatusCodeError{
+		Code: fasthttp.StatusTooManyRequests,
+		Msg:  "too many upstream requests; try again later",
+	}
+}
+
+// wait waits until a request of family can be sent, and returns the function which must be
+// called after the request. Returns 429 *StatusCodeError if the request can't be sent in
+// l.maxWait, or ctx.Err() if ctx is done.
+func (l *limiter) wait(ctx context.Context, family string) (func(), error) {
+	deadline := time.Now().Add(l.maxWait)
+
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	var reserved []*bucket
+	var delay time.Duration
+
+	cancel := func() {
+		for _, r := range reserved {
+			r.cancel()
+		}
+	}
+
+	for _, b := range []*bucket{l.family[family], l.global} {
+		if b == nil {
+			continue
+		}
+
+		wait, ok := b.reserve(l.maxWait)
+		if !ok {
+			cancel()
+			l.limited.Add(1)
+			return nil, limitedError()
+		}
+
+		reserved = append(reserved, b)
+		if wait > delay {
+			delay = wait
+		}
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			cancel()
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(time.Until(deadline))
+			select {
+			case l.slots <- struct{}{}:
+				timer.Stop()
+			case <-timer.C:
+				l.limited.Add(1)
+				return nil, limitedError()
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	l.inFlight.Add(1)
+
+	return func() {
+		l.inFlight.Add(-1)
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+func (l *limiter) stats() LimiterStats {
+	return LimiterStats{InFlight: l.inFlight.Load(), Waiting: l.waiting.Load(), Limited: l.limited.Load()}
+}
+
+// Returns the state of rate limiter.
+func (s *Session) LimiterStats() LimiterStats { return s.limiter.stats() }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Retry policy of upstream requests. Only GET and HEAD requests are retried.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one; zero or one disables retrying.
+	MaxAttempts int
+
+	// Backoff before the n-th retry is BackoffBase * 2^(n-1), limited to BackoffCap
+	// ( default 100ms and 2s ).
+	BackoffBase time.Duration
+	BackoffCap  time.Duration
+
+	// Fraction of backoff which is randomized, between 0 and 1; e.g. 0.5 means the backoff
+	// is between 50% and 100% of its value ( zero means no jitter ).
+	Jitter float64
+
+	// Status codes of upstream responses which are retried ( default 502, 503 and 504 )
+	RetryStatusCodes []int
+
+	// Reports whether a request error is retried ( default IsRetryableError )
+	RetryError func(err error) bool
+}
+
+// Default status codes which are retried
+var DefaultRetryStatusCodes = []int{
+	fasthttp.StatusBadGateway, fasthttp.StatusServiceUnavailable, fasthttp.StatusGatewayTimeout,
+}
+
+// IsRetryableError reports whether err is a transient network error; e.g. timeouts,
+// refused or reset connections, and connections which are closed by upstream.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrDialTimeout) ||
+		errors.Is(err, fasthttp.ErrConnectionClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.BackoffBase <= 0 {
+		p.BackoffBase = time.Millisecond * 100
+	}
+	if p.BackoffCap <= 0 {
+		p.BackoffCap = time.Second * 2
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	} else if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.RetryStatusCodes == nil {
+		p.RetryStatusCodes = DefaultRetryStatusCodes
+	}
+	if p.RetryError == nil {
+		p.RetryError = IsRetryableError
+	}
+}
+
+// backoff returns the duration to wait before the n-th retry (n >= 1).
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d := p.BackoffCap
+	if n < 32 {
+		if exp := p.BackoffBase << (n - 1); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+
+	if p.Jitter > 0 {
+		random := time.Duration(float64(d) * p.Jitter)
+		if random > 0 {
+			d = d - random + time.Duration(rand.Int63n(int64(random)+1))
+		}
+	}
+	return d
+}
+
+func (p *RetryPolicy) retryStatusCode(code int) bool {
+	for _, c := range p.RetryStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/awolverp/kickcore/logging"
@@ -15,21 +21,113 @@ const (
 
 type Session struct {
 	logger *logging.FileLogger
-	app    fasthttp.Client
+	app    *fasthttp.Client
+	retry  RetryPolicy
+
+	// Number of upstream attempts; see s.WithCounter
+	attempts *atomic.Int64
+
+	// Circuit breakers of endpoint families; shared between copies of session
+	breakers *breakers
+
+	// Rate limiter of requests; shared between copies of session
+	limiter *limiter
+
+	// Upstream base URL (scheme and host) and path prefix, without trailing slash
+	baseURL    string
+	pathPrefix string
+}
+
+type SessionConfig struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Base URL of upstream API, e.g. "http://127.0.0.1:8000" ( default is the original football API );
+	// it can be a mirror, a caching proxy or a mock server. Its path (if any) is used as path prefix.
+	BaseURL string
+
+	// Path prefix which is added before the paths of API, e.g. "/mirror"
+	PathPrefix string
+
+	// Retry policy of failed requests ( default no retry )
+	Retry RetryPolicy
+
+	// Circuit breakers of endpoint families ( default disabled )
+	Breaker BreakerConfig
+
+	// Rate limiter of requests ( default unlimited )
+	Limiter LimiterConfig
 }
 
 func NewSession(logger *logging.FileLogger, readTimeout, writeTimeout time.Duration) *Session {
+	s, _ := NewSessionWithConfig(logger, &SessionConfig{ReadTimeout: readTimeout, WriteTimeout: writeTimeout})
+	return s
+}
+
+func NewSessionWithConfig(logger *logging.FileLogger, c *SessionConfig) (*Session, error) {
+	if c == nil {
+		return nil, errors.New("argument (*SessionConfig) is nil")
+	}
+
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = hostAddr
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("invalid base url: '" + baseURL + "' ( e.g. 'http://127.0.0.1:8000' )")
+	}
+
+	limiter, err := newLimiter(c.Limiter)
+	if err != nil {
+		return nil, err
+	}
+
 	s := new(Session)
-	s.app = fasthttp.Client{
+	s.limiter = limiter
+	s.app = &fasthttp.Client{
 		Name:         defaultUserAgent,
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
 	}
 	s.logger = logger
+	s.retry = c.Retry
+	s.retry.setDefaults()
+	s.breakers = newBreakers(c.Breaker)
+	s.baseURL = u.Scheme + "://" + u.Host
+	s.pathPrefix = cleanPrefix(u.EscapedPath()) + cleanPrefix(c.PathPrefix)
 
-	return s
+	return s, nil
+}
+
+// cleanPrefix adds leading slash to prefix, and removes its trailing slashes.
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
+
+// Returns the upstream base URL, including the path prefix.
+func (s *Session) BaseURL() string { return s.baseURL + s.pathPrefix }
+
+// WithCounter returns a copy of session which adds the number of its upstream attempts
+// (including retries) to counter. The copy shares connections and configuration with s.
+func (s *Session) WithCounter(counter *atomic.Int64) *Session {
+	copied := *s
+	copied.attempts = counter
+	return &copied
 }
 
+// endpoint returns the upstream URL of path.
+func (s *Session) endpoint(path string) string { return s.baseURL + s.pathPrefix + path }
+
 func (s *Session) log(level int, msg string, args ...interface{}) int {
 	if s.logger != nil {
 		return s.logger.Log(level, msg, args...)
@@ -40,12 +138,52 @@ func (s *Session) log(level int, msg string, args ...interface{}) int {
 type RequestConfig struct {
 	Method, URI, Accept, Referer string
 
+	// Endpoint family of request, e.g. FAMILY_MATCH; requests without family
+	// aren't guarded by circuit breakers.
+	Family string
+
 	CloseConnection bool
 }
 
+// Request is like RequestContext, but the request can't be canceled.
 func (s *Session) Request(r RequestConfig, f func(*fasthttp.Response) error) error {
+	return s.RequestContext(context.Background(), r, f)
+}
+
+// RequestContext sends the request and calls f with the response. Failed GET and HEAD requests
+// are retried by the retry policy of session (see RetryPolicy); f is called with the response
+// of last attempt.
+//
+// If the circuit breaker of request family is open, returns 503 *StatusCodeError without
+// sending the request (see BreakerConfig).
+//
+// Requests wait for the rate limiter of session, and return 429 *StatusCodeError if they can't
+// be sent in time (see LimiterConfig).
+//
+// The request (including waiting for rate limiter and backoffs) is canceled when ctx is done,
+// and returns ctx.Err(); the deadline of ctx is used as the deadline of upstream calls.
+func (s *Session) RequestContext(ctx context.Context, r RequestConfig, f func(*fasthttp.Response) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	br := s.breakers.get(r.Family)
+	if br != nil && !br.allow(&s.breakers.config) {
+		return breakerError(r.Family)
+	}
+
+	// canceled requests aren't upstream failures
+	canceled := func() error {
+		if br != nil {
+			br.cancel()
+		}
+		return ctx.Err()
+	}
+
 	// Request
 	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
 	req.Header.SetMethod(r.Method)
 	req.SetRequestURI(r.URI)
 
@@ -65,16 +203,88 @@ func (s *Session) Request(r RequestConfig, f func(*fasthttp.Response) error) err
 
 	// Response
 	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
 
-	// Send
-	s.log(
-		logging.LEVEL_DEBUG, "HTTP Request: '%s %s' ...", r.Method, req.URI().Path(),
-	)
-	err := s.app.Do(req, resp)
+	maxAttempts := s.retry.MaxAttempts
+	if maxAttempts < 1 || (r.Method != fasthttp.MethodGet && r.Method != fasthttp.MethodHead) {
+		maxAttempts = 1
+	}
 
-	// Release
-	fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		release, waitErr := s.limiter.wait(ctx, r.Family)
+		if waitErr != nil {
+			if ctx.Err() != nil {
+				return canceled()
+			}
+
+			s.log(
+				logging.LEVEL_WARNING, "HTTP Request: '%s %s' is rate limited (attempt %d)", r.Method, req.URI().Path(), attempt,
+			)
+
+			if attempt > 1 {
+				// the result of last attempt
+				break
+			}
+
+			if br != nil {
+				br.cancel()
+			}
+			return waitErr
+		}
+
+		// Send
+		s.log(
+			logging.LEVEL_DEBUG, "HTTP Request: '%s %s' ...", r.Method, req.URI().Path(),
+		)
+
+		resp.Reset()
+		err = s.do(ctx, req, resp)
+		release()
+
+		if s.attempts != nil {
+			s.attempts.Add(1)
+		}
+
+		if ctx.Err() != nil {
+			return canceled()
+		}
+
+		var reason string
+		if err != nil {
+			if !s.retry.RetryError(err) {
+				break
+			}
+			reason = err.Error()
+		} else if s.retry.retryStatusCode(resp.StatusCode()) {
+			reason = "status code [" + strconv.Itoa(resp.StatusCode()) + "]"
+		} else {
+			break
+		}
+
+		if attempt >= maxAttempts {
+			break
+		}
+
+		backoff := s.retry.backoff(attempt)
+		s.log(
+			logging.LEVEL_DEBUG, "HTTP Request: '%s %s' failed (attempt %d/%d): %s; retrying in %v ...",
+			r.Method, req.URI().Path(), attempt, maxAttempts, reason, backoff,
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return canceled()
+		}
+	}
+
+	if br != nil {
+		s.breakerDone(br, r.Family, err != nil || resp.StatusCode() >= 500)
+	}
 
 	if err != nil {
 		return err
@@ -83,9 +293,58 @@ func (s *Session) Request(r RequestConfig, f func(*fasthttp.Response) error) err
 	return f(resp)
 }
 
+// do sends req and fills resp; the deadline of ctx is used as the deadline of request.
+// If ctx is done before the response, returns ctx.Err() immediately.
+func (s *Session) do(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	deadline, hasDeadline := ctx.Deadline()
+
+	send := func(req *fasthttp.Request, resp *fasthttp.Response) error {
+		if hasDeadline {
+			return s.app.DoDeadline(req, resp, deadline)
+		}
+		return s.app.Do(req, resp)
+	}
+
+	if ctx.Done() == nil {
+		return send(req, resp)
+	}
+
+	// the copies are sent, so req and resp are never used after returning
+	reqCopy := fasthttp.AcquireRequest()
+	respCopy := fasthttp.AcquireResponse()
+	req.CopyTo(reqCopy)
+
+	result := make(chan error, 1)
+	go func() { result <- send(reqCopy, respCopy) }()
+
+	release := func() {
+		fasthttp.ReleaseRequest(reqCopy)
+		fasthttp.ReleaseResponse(respCopy)
+	}
+
+	select {
+	case err := <-result:
+		respCopy.CopyTo(resp)
+		release()
+		return err
+
+	case <-ctx.Done():
+		go func() {
+			<-result
+			release()
+		}()
+		return ctx.Err()
+	}
+}
+
 func (s *Session) RequestJSON(req RequestConfig, obj interface{}) error {
+	return s.RequestJSONContext(context.Background(), req, obj)
+}
+
+// RequestJSONContext is like RequestJSON, but the request is canceled when ctx is done.
+func (s *Session) RequestJSONContext(ctx context.Context, req RequestConfig, obj interface{}) error {
 	req.Accept = "application/json"
-	return s.Request(req, func(r *fasthttp.Response) error {
+	return s.RequestContext(ctx, req, func(r *fasthttp.Response) error {
 		body := r.Body()
 		code := r.StatusCode()
 
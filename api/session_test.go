@@ -0,0 +1,260 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+)
+
+func TestSessionBaseURL(t *testing.T) {
+	var path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":1,"results":[{"name":"Iran","seasons":[{"id":"1","name":"1402"}]}]}`))
+	}))
+	defer server.Close()
+
+	s, err := api.NewSessionWithConfig(nil, &api.SessionConfig{BaseURL: server.URL + "/mirror/", PathPrefix: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.BaseURL() != server.URL+"/mirror/v1" {
+		t.Fatalf("unexpected base url: %q", s.BaseURL())
+	}
+
+	regions, err := s.GetTransfersRegions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/mirror/v1/api/transfers/regions/" || regions.Count != 1 {
+		t.Fatalf("unexpected request: %q, %+v", path, regions)
+	}
+
+	for _, baseURL := range []string{"127.0.0.1:8000", "ftp://127.0.0.1", "http://", "http://127.0.0.1/?q=1"} {
+		if _, err := api.NewSessionWithConfig(nil, &api.SessionConfig{BaseURL: baseURL}); err == nil {
+			t.Fatalf("expected error for %q", baseURL)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n := calls.Add(1); {
+		case r.URL.Path == "/api/transfers/transfer-seasons/404/transfers/":
+			w.WriteHeader(http.StatusNotFound)
+		case n < 3:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"count":0,"results":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	s, err := api.NewSessionWithConfig(nil, &api.SessionConfig{
+		BaseURL: server.URL,
+		Retry:   api.RetryPolicy{MaxAttempts: 3, BackoffBase: time.Millisecond, Jitter: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts atomic.Int64
+	if _, err = s.WithCounter(&attempts).GetTransfersRegions(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 3 {
+		t.Fatalf("unexpected attempts: %d", attempts.Load())
+	}
+
+	// not retryable
+	calls.Store(0)
+	_, err = s.GetTransfers("404")
+	if e, ok := err.(*api.StatusCodeError); !ok || e.Code != 404 || calls.Load() != 1 {
+		t.Fatalf("unexpected result: %v, calls=%d", err, calls.Load())
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var calls atomic.Int64
+	var down atomic.Bool
+	down.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"count":0,"results":[]}`))
+	}))
+	defer server.Close()
+
+	s, err := api.NewSessionWithConfig(nil, &api.SessionConfig{
+		BaseURL: server.URL,
+		Breaker: api.BreakerConfig{Threshold: 2, OpenTimeout: time.Millisecond * 50},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		s.GetTransfersRegions()
+	}
+
+	_, err = s.GetTransfersRegions()
+	if e, ok := err.(*api.StatusCodeError); !ok || e.Code != 503 || calls.Load() != 2 {
+		t.Fatalf("breaker isn't open: %v, calls=%d", err, calls.Load())
+	}
+
+	stats := s.BreakerStats()
+	if stats[api.FAMILY_TRANSFERS].State != api.BREAKER_OPEN || stats[api.FAMILY_TRANSFERS].Rejected != 3 {
+		t.Fatalf("unexpected stats: %+v", stats[api.FAMILY_TRANSFERS])
+	}
+
+	// other families aren't affected
+	if stats[api.FAMILY_MATCH].State != api.BREAKER_CLOSED {
+		t.Fatalf("unexpected stats: %+v", stats[api.FAMILY_MATCH])
+	}
+
+	// probe
+	down.Store(false)
+	time.Sleep(time.Millisecond * 60)
+
+	if _, err = s.GetTransfersRegions(); err != nil {
+		t.Fatal(err)
+	}
+	if state := s.BreakerStats()[api.FAMILY_TRANSFERS].State; state != api.BREAKER_CLOSED {
+		t.Fatalf("breaker isn't closed: %s", state)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	unblock := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/transfers/transfer-seasons/block/transfers/" {
+			<-unblock
+		}
+		w.Write([]byte(`{"count":0,"results":[]}`))
+	}))
+	defer server.Close()
+
+	s, err := api.NewSessionWithConfig(nil, &api.SessionConfig{
+		BaseURL: server.URL,
+		Limiter: api.LimiterConfig{
+			Rate: 100, FamilyRate: map[string]float64{api.FAMILY_TRANSFERS: 10}, MaxWait: time.Millisecond * 50,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err = s.GetTransfersRegions(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = s.GetTransfersRegions()
+	if e, ok := err.(*api.StatusCodeError); !ok || e.Code != 429 {
+		t.Fatalf("request isn't limited: %v", err)
+	}
+
+	// other families aren't limited by the family rate
+	_, err = s.GetMatchInfo("1")
+	if e, ok := err.(*api.StatusCodeError); ok && e.Code == 429 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// in-flight requests
+	s, _ = api.NewSessionWithConfig(nil, &api.SessionConfig{
+		BaseURL: server.URL, Limiter: api.LimiterConfig{MaxInFlight: 1, MaxWait: time.Millisecond * 50},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.GetTransfers("block")
+		close(done)
+	}()
+
+	for s.LimiterStats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = s.GetTransfersRegions()
+	if e, ok := err.(*api.StatusCodeError); !ok || e.Code != 429 {
+		t.Fatalf("request isn't limited: %v", err)
+	}
+
+	close(unblock)
+	<-done
+
+	if stats := s.LimiterStats(); stats.InFlight != 0 || stats.Limited != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	_, err = api.NewSessionWithConfig(nil, &api.SessionConfig{
+		Limiter: api.LimiterConfig{FamilyRate: map[string]float64{"unknown": 1}},
+	})
+	if err == nil {
+		t.Fatal("unknown family is accepted")
+	}
+}
+
+func TestContext(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-time.After(time.Second * 5):
+		}
+	}))
+	defer server.Close()
+
+	s, err := api.NewSessionWithConfig(nil, &api.SessionConfig{
+		BaseURL: server.URL,
+		Breaker: api.BreakerConfig{Threshold: 1},
+		Retry:   api.RetryPolicy{MaxAttempts: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err = s.GetMatchInfoContext(ctx, "1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	if _, err = s.GetMatchInfoContext(ctx, "1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("requests aren't canceled in time: %v", elapsed)
+	}
+
+	// canceled requests aren't upstream failures
+	if state := s.BreakerStats()[api.FAMILY_MATCH].State; state != api.BREAKER_CLOSED {
+		t.Fatalf("unexpected breaker state: %s", state)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"reflect"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	"github.com/awolverp/kickcore/api"
 	"github.com/awolverp/kickcore/logging"
 )
 
@@ -17,15 +20,17 @@ type CacheDriver interface {
 	// Pings cache connection
 	PingContext(ctx context.Context) error
 
-	// Inserts key-value to cache
-	// Returns false if key is currently in cache
-	Insert(key string, value []byte, date int64) (bool, error)
+	// Inserts key-value to cache; the value expires at expiresAt (unix time).
+	// Replaces the value if it's expired.
+	// Returns false if key is currently in cache and isn't expired.
+	Insert(key string, value []byte, expiresAt int64) (bool, error)
 
-	// Selects the value specified by key in cache.
-	Select(key string, value *[]byte) error
+	// Selects the value and its expiration time specified by key in cache.
+	// Expired values are never selected, even if they're not deleted yet.
+	Select(key string, value *[]byte, expiresAt *int64) error
 
-	// Selects the keys which are expired.
-	SelectExpiredValues(expireAfter int64) ([]string, error)
+	// Selects the keys which are expired at now (unix time).
+	SelectExpired(now int64) ([]string, error)
 
 	// Deletes the value specified by key in cache.
 	Delete(key string) (bool, error)
@@ -33,16 +38,46 @@ type CacheDriver interface {
 	// Deletes the values which are specified by any key in cache.
 	DeleteMany(keys []string) (int64, error)
 
+	// Calls f for every value which its key starts with prefix (including expired values)
+	// until f returns false. f must not modify the cache.
+	Range(prefix string, f func(key string, value []byte, expiresAt int64) bool) error
+
+	// Like Range, but values aren't read; it's used for iterating over keys.
+	RangeKeys(prefix string, f func(key string, expiresAt int64) bool) error
+
 	// Returns the length of cache
 	Len() (int64, error)
 
+	// Returns the size of cache in bytes (e.g. size of database file)
+	Size() (int64, error)
+
 	// Closes cache
 	Close() error
 }
 
+// LoggingDriver is implemented by cache drivers which log (e.g. the file driver logs the
+// records which are dropped on recovery); SetLogger must be called before Init.
+type LoggingDriver interface {
+	SetLogger(logger *logging.FileLogger)
+}
+
 type Cache struct {
 	driver CacheDriver
 
+	// Coalesces concurrent misses on the same key
+	flight    group
+	coalesced atomic.Uint64
+
+	stats statistics
+
+	// Codec which values are compressed by before inserting ( default CODEC_NONE );
+	// values are decompressed by their own codec, so it can be changed at any time.
+	Codec Codec
+
+	// Codecs which compressed variants of values are stored by, alongside the value;
+	// see c.CacheFuncJSONEncoded.
+	Encodings []Codec
+
 	// It's true if you call c.Close()
 	Closed bool
 	Logger *logging.FileLogger
@@ -81,21 +116,191 @@ func (c *Cache) Ping(timeout time.Duration) error {
 	return c.driver.PingContext(ctx)
 }
 
+// State of the data which is returned by c.CacheFunc
+type State uint8
+
+const (
+	// Data is fetched from upstream
+	STATE_MISS State = iota
+
+	// Data is fresh and returned from cache
+	STATE_HIT
+
+	// Data is expired and returned from cache; see TTLConfig.StaleWhileRevalidate
+	// and TTLConfig.StaleIfError
+	STATE_STALE
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_HIT:
+		return "HIT"
+	case STATE_STALE:
+		return "STALE"
+	default:
+		return "MISS"
+	}
+}
+
+func (c *Cache) log(level int, msg string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Log(level, msg, args...)
+	}
+}
+
+// Returns the unix time that value is deleted from cache.
+func expireDate(c TTLConfig, freshUntil int64) int64 {
+	stale := c.StaleWhileRevalidate
+	if c.StaleIfError > stale {
+		stale = c.StaleIfError
+	}
+	return freshUntil + stale
+}
+
+func (c *Cache) insert(apikey APICacheKey, key string, value []byte, ttl int64) (bool, error) {
+	freshUntil := time.Now().Unix() + ttl
+
+	ok, err := c.driver.Insert(
+		apikey.prefix()+key, encodeEntry(c.compressEntry(entry{freshUntil: freshUntil, value: value})), expireDate(apikey.TTL(), freshUntil),
+	)
+	c.countInsert(apikey, ok)
+	return ok, err
+}
+
+func (c *Cache) countInsert(apikey APICacheKey, ok bool) {
+	if ok {
+		c.stats.get(apikey.Key).inserts.Add(1)
+	} else {
+		c.stats.get(apikey.Key).insertFailures.Add(1)
+	}
+}
+
+// compressEntry compresses the value of e by c.Codec, and adds its variants compressed
+// by c.Encodings; small values are stored uncompressed.
+func (c *Cache) compressEntry(e entry) entry {
+	if len(e.value) < compressMinSize {
+		return e
+	}
+
+	plain := e.value
+
+	if c.Codec != CODEC_NONE {
+		value, err := compress(c.Codec, plain)
+		if err != nil {
+			c.log(logging.LEVEL_WARNING, "Cache: compressing by %s: %s", c.Codec.String(), err.Error())
+		} else {
+			e.codec = c.Codec
+			e.value = value
+		}
+	}
+
+	for _, codec := range c.Encodings {
+		if codec == CODEC_NONE || codec == e.codec {
+			continue
+		}
+
+		value, err := compress(codec, plain)
+		if err != nil {
+			c.log(logging.LEVEL_WARNING, "Cache: compressing by %s: %s", codec.String(), err.Error())
+			continue
+		}
+
+		e.variants = append(e.variants, variant{codec: codec, value: value})
+	}
+
+	return e
+}
+
+// selectEntry selects the value of key and decodes it; the value of returned entry is
+// encoded by its codec (see entry.body).
+// Returns nil if key isn't in cache, and false if the value can't be decoded
+// (unknown header version or corrupted compressed value).
+func (c *Cache) selectEntry(key string, accept []Codec) (*entry, int64, bool, error) {
+	var value []byte
+	var expiresAt int64
+	err := c.driver.Select(key, &value, &expiresAt)
+	if value == nil {
+		return nil, 0, true, err
+	}
+
+	e, ok := decodeEntry(value)
+	if !ok {
+		return &e, expiresAt, false, err
+	}
+
+	codec := e.codec
+
+	e.value, e.codec, err = e.body(accept)
+	if err != nil {
+		c.log(logging.LEVEL_WARNING, "Cache: decompressing '%s' by %s: %s", key, codec.String(), err.Error())
+		return &e, expiresAt, false, nil
+	}
+	e.variants = nil
+
+	return &e, expiresAt, true, nil
+}
+
+// insertNegative inserts the error for ttl seconds; negative values are never served stale.
+func (c *Cache) insertNegative(apikey APICacheKey, key string, e *api.StatusCodeError, ttl int64) (bool, error) {
+	freshUntil := time.Now().Unix() + ttl
+
+	value, _ := e.MarshalJSON()
+	ok, err := c.driver.Insert(
+		apikey.prefix()+key, encodeEntry(entry{freshUntil: freshUntil, flags: entryNegative, value: value}), freshUntil,
+	)
+	c.countInsert(apikey, ok)
+	return ok, err
+}
+
+// Returns the error if it should be cached as negative value.
+func negativeError(c TTLConfig, err error) (*api.StatusCodeError, bool) {
+	if c.NegativeTTL <= 0 {
+		return nil, false
+	}
+
+	e, ok := err.(*api.StatusCodeError)
+	// 429 means the request is rate limited, not that the value is missing
+	if !ok || e.Code < 400 || e.Code >= 500 || e.Code == 429 {
+		return nil, false
+	}
+
+	return e, true
+}
+
+// Reports whether obj is nil or an empty slice or map.
+func isEmpty(obj interface{}) bool {
+	if obj == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+
+	return false
+}
+
 // Insert key-value into the cache.
 func (c *Cache) Insert(apikey APICacheKey, key string, value []byte) (bool, error) {
-	return c.driver.Insert(apikey.Key+key, value, time.Now().Unix()+apikey.ExtraTTL)
+	return c.insert(apikey, key, value, apikey.TTL().ExtraTTL)
 }
 
 // Select value specified by the key.
 func (c *Cache) Select(apikey APICacheKey, key string) ([]byte, error) {
-	var value []byte
-	err := c.driver.Select(apikey.Key+key, &value)
-	return value, err
+	e, _, ok, err := c.selectEntry(apikey.prefix()+key, nil)
+	if e == nil || !ok || e.flags&entryNegative != 0 {
+		return nil, err
+	}
+	return e.value, err
 }
 
 // Delete value specified by the key.
 func (c *Cache) Delete(apikey APICacheKey, key string) (bool, error) {
-	return c.driver.Delete(apikey.Key + key)
+	return c.driver.Delete(apikey.prefix() + key)
 }
 
 // Delete values which are specified by the keys.
@@ -103,49 +308,316 @@ func (c *Cache) DeleteMany(key []string) (int64, error) {
 	return c.driver.DeleteMany(key)
 }
 
-// Cache length
-func (c *Cache) Len() (int64, error) { return c.driver.Len() }
+// Information of a key in cache
+type KeyInfo struct {
+	// Key without APICacheKey.Key prefix
+	Key string `json:"key"`
 
-// CacheFunc first tries to returns value from cache, then if key not found in cache, call 'f'
-// and (if it not returned error,) insert returned data into cache
-//
-// returns (data, data is in cache, error)
-func (c *Cache) CacheFunc(apikey APICacheKey, key string, f func() ([]byte, error)) ([]byte, bool, error) {
-	var value []byte = nil
-	err := c.driver.Select(apikey.Key+key, &value)
-	if value != nil {
-		return value, true, err
+	// Expiration time (unix time)
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// Keys returns the keys of apikey which are in cache.
+func (c *Cache) Keys(apikey APICacheKey) ([]KeyInfo, error) {
+	keys := []KeyInfo{}
+
+	prefix := apikey.prefix()
+
+	err := c.driver.RangeKeys(prefix, func(key string, expiresAt int64) bool {
+		keys = append(keys, KeyInfo{Key: key[len(prefix):], ExpiresAt: expiresAt})
+		return true
+	})
+
+	return keys, err
+}
+
+// SelectRaw returns the stored value specified by the key and its expiration time.
+// Unlike c.Select, negative values (cached errors) are returned too.
+func (c *Cache) SelectRaw(apikey APICacheKey, key string) ([]byte, int64, error) {
+	e, expiresAt, ok, err := c.selectEntry(apikey.prefix()+key, nil)
+	if e == nil || !ok {
+		return nil, 0, err
 	}
+	return e.value, expiresAt, err
+}
 
-	value, err = f()
+// DeleteAll deletes all values of apikey (of any version).
+func (c *Cache) DeleteAll(apikey APICacheKey) (int64, error) {
+	if apikey.Key == "" {
+		return 0, errors.New("namespace is empty")
+	}
+
+	var keys []string
+
+	err := c.driver.RangeKeys(apikey.Key, func(key string, _ int64) bool {
+		keys = append(keys, key)
+		return true
+	})
 	if err != nil {
-		return value, false, err
+		return 0, err
 	}
 
-	_, err = c.driver.Insert(apikey.Key+key, value, time.Now().Unix()+apikey.ExtraTTL)
-	return value, err == nil, err
+	return c.driver.DeleteMany(keys)
 }
 
-// Like c.CacheFunc, but recieve interface{} from 'f' and convert it to bytes by json.Marshal.
-func (c *Cache) CacheFuncJSON(apikey APICacheKey, key string, f func() (interface{}, error)) ([]byte, bool, error) {
-	var value []byte = nil
-	err := c.driver.Select(apikey.Key+key, &value)
-	if value != nil {
-		return value, true, err
+// DeleteExpired deletes the values which are expired.
+func (c *Cache) DeleteExpired() (int64, error) {
+	if c.Closed {
+		return 0, errors.New("cache is closed")
 	}
 
-	valueInterface, err := f()
+	keys, err := c.driver.SelectExpired(time.Now().Unix())
 	if err != nil {
-		return nil, false, err
+		return 0, err
 	}
 
-	value, err = json.Marshal(valueInterface)
-	if err != nil {
-		return nil, false, err
+	n, err := c.driver.DeleteMany(keys)
+	if err == nil {
+		c.stats.countExpired(keys)
+	}
+	return n, err
+}
+
+// DeleteOldVersions deletes the values which are stored by other versions of their APICacheKey
+// (see APICacheKey.Version); they're never selected.
+func (c *Cache) DeleteOldVersions() (int64, error) {
+	var keys []string
+
+	err := c.driver.RangeKeys("", func(key string, _ int64) bool {
+		if _, _, current := namespaceOf(key); !current {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	return c.driver.DeleteMany(keys)
+}
+
+// Cache length
+func (c *Cache) Len() (int64, error) { return c.driver.Len() }
+
+// Cache size in bytes
+func (c *Cache) Size() (int64, error) { return c.driver.Size() }
+
+// Returns the statistics of each APICacheKey by its name (e.g. "MATCH_INFO").
+func (c *Cache) Stats() map[string]Stats { return c.stats.all() }
+
+// Returns the number of callers that shared another caller's upstream call
+// instead of calling it themselves.
+func (c *Cache) Coalesced() uint64 { return c.coalesced.Load() }
+
+// fetchFunc returns the value and its TTL in seconds; ctx is canceled when nobody waits for the value.
+type fetchFunc func(ctx context.Context) ([]byte, int64, error)
+
+// fetch calls 'f' and inserts returned data into cache. concurrent calls of fetch
+// with the same key share a single call of 'f'.
+//
+// If replace is true, the current value of key is replaced.
+// If ctx is done, fetch returns ctx.Err(), and the call of 'f' is canceled unless other callers share it.
+func (c *Cache) fetch(
+	ctx context.Context, apikey APICacheKey, key string, replace bool, f fetchFunc,
+) ([]byte, Codec, State, error) {
+	value, shared, err := c.flight.do(ctx, apikey.prefix()+key, c.fetcher(apikey, key, replace, f))
+
+	if shared {
+		c.coalesced.Add(1)
+	}
+
+	return value, CODEC_NONE, STATE_MISS, err
+}
+
+func (c *Cache) fetcher(apikey APICacheKey, key string, replace bool, f fetchFunc) func(context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		counters := c.stats.get(apikey.Key)
+		start := time.Now()
+
+		value, ttl, err := f(ctx)
+
+		counters.fetchTime.Add(int64(time.Since(start)))
+		counters.fetches.Add(1)
+
+		if err != nil {
+			cfg := apikey.TTL()
+			if e, ok := negativeError(cfg, err); ok {
+				if replace {
+					c.driver.Delete(apikey.prefix() + key)
+				}
+				c.insertNegative(apikey, key, e, cfg.NegativeTTL)
+			}
+			return value, err
+		}
+
+		if replace {
+			c.driver.Delete(apikey.prefix() + key)
+		}
+
+		_, err = c.insert(apikey, key, value, ttl)
+		return value, err
+	}
+}
+
+// lookup returns value of key from cache, and calls 'f' if the value is missing or expired.
+//
+// Values from cache are returned encoded by the first codec of accept which they have,
+// and values from 'f' are returned as is (CODEC_NONE).
+func (c *Cache) lookup(
+	ctx context.Context, apikey APICacheKey, key string, accept []Codec, f fetchFunc,
+) ([]byte, Codec, State, error) {
+	counters := c.stats.get(apikey.Key)
+
+	e, _, ok, err := c.selectEntry(apikey.prefix()+key, accept)
+	if e == nil {
+		counters.misses.Add(1)
+		return c.fetch(ctx, apikey, key, false, f)
+	}
+
+	if !ok {
+		counters.misses.Add(1)
+		return c.fetch(ctx, apikey, key, true, f)
+	}
+
+	now := time.Now().Unix()
+	if e.flags&entryNegative != 0 {
+		if now < e.freshUntil {
+			statusErr := new(api.StatusCodeError)
+			if json.Unmarshal(e.value, statusErr) == nil {
+				counters.hits.Add(1)
+				return nil, CODEC_NONE, STATE_HIT, statusErr
+			}
+		}
+		counters.misses.Add(1)
+		return c.fetch(ctx, apikey, key, true, f)
+	}
+
+	if e.freshUntil == 0 || now < e.freshUntil {
+		counters.hits.Add(1)
+		return e.value, e.codec, STATE_HIT, err
+	}
+
+	age := now - e.freshUntil
+	cfg := apikey.TTL()
+
+	if age < cfg.StaleWhileRevalidate {
+		c.flight.doAsync(apikey.prefix()+key, c.fetcher(apikey, key, true, f), func(err error) {
+			c.log(logging.LEVEL_WARNING, "Cache: revalidating '%s': %s", apikey.prefix()+key, err.Error())
+		})
+		counters.stale.Add(1)
+		return e.value, e.codec, STATE_STALE, nil
+	}
+
+	counters.misses.Add(1)
+
+	if age < cfg.StaleIfError {
+		value, codec, state, err := c.fetch(ctx, apikey, key, true, f)
+		// the caller which has left (ctx is done) doesn't need the stale value
+		if err != nil && value == nil && ctx.Err() == nil {
+			c.log(logging.LEVEL_WARNING, "Cache: serving stale '%s': %s", apikey.prefix()+key, err.Error())
+			counters.stale.Add(1)
+			return e.value, e.codec, STATE_STALE, nil
+		}
+		return value, codec, state, err
 	}
 
-	_, err = c.driver.Insert(apikey.Key+key, value, time.Now().Unix()+apikey.ExtraTTL)
-	return value, err == nil, err
+	return c.fetch(ctx, apikey, key, true, f)
+}
+
+// CacheFunc first tries to returns value from cache, then if key not found in cache, call 'f'
+// and (if it not returned error,) insert returned data into cache.
+//
+// returns (data, data is in cache, error); see c.CacheFuncState for details.
+func (c *Cache) CacheFunc(apikey APICacheKey, key string, f func() ([]byte, error)) ([]byte, bool, error) {
+	value, state, err := c.CacheFuncState(apikey, key, f)
+	return value, state != STATE_MISS || err == nil, err
+}
+
+// Like c.CacheFunc, but recieve interface{} from 'f' and convert it to bytes by json.Marshal.
+//
+// returns (data, data is in cache, error); see c.CacheFuncJSONState for details.
+func (c *Cache) CacheFuncJSON(apikey APICacheKey, key string, f func() (interface{}, error)) ([]byte, bool, error) {
+	value, state, err := c.CacheFuncJSONState(apikey, key, f)
+	return value, state != STATE_MISS || err == nil, err
+}
+
+// Like c.CacheFunc, but returns the state of data instead of whether it's in cache.
+// Concurrent misses on the same key share a single call of 'f'.
+//
+// If NegativeTTL of apikey is set, 4xx *api.StatusCodeError errors of 'f' are cached for
+// NegativeTTL and returned as error.
+//
+// Expired values are returned while they are refreshing in background in
+// StaleWhileRevalidate window of apikey, or if 'f' fails in StaleIfError window of apikey.
+//
+// returns (data, state of data, error)
+func (c *Cache) CacheFuncState(apikey APICacheKey, key string, f func() ([]byte, error)) ([]byte, State, error) {
+	value, _, state, err := c.lookup(context.Background(), apikey, key, nil, func(context.Context) ([]byte, int64, error) {
+		value, err := f()
+		return value, apikey.TTL().ExtraTTL, err
+	})
+	return value, state, err
+}
+
+// Like c.CacheFuncState, but recieve interface{} from 'f' and convert it to bytes by json.Marshal.
+//
+// If apikey.Policy is set, TTL of the value is chosen by the policy.
+// If NegativeTTL of apikey is set, empty values (nil, empty slices and maps) are cached for NegativeTTL.
+func (c *Cache) CacheFuncJSONState(apikey APICacheKey, key string, f func() (interface{}, error)) ([]byte, State, error) {
+	value, _, state, err := c.lookup(context.Background(), apikey, key, nil, jsonFetchFunc(apikey, withoutContext(f)))
+	return value, state, err
+}
+
+// Like c.CacheFuncJSONState, but if the value in cache has a variant compressed by any codec of accept
+// (see c.Encodings), returns the compressed variant without decompressing or compressing it.
+// accept is in order of preference.
+//
+// returns (data, codec of data, state of data, error)
+func (c *Cache) CacheFuncJSONEncoded(
+	apikey APICacheKey, key string, accept []Codec, f func() (interface{}, error),
+) ([]byte, Codec, State, error) {
+	return c.lookup(context.Background(), apikey, key, accept, jsonFetchFunc(apikey, withoutContext(f)))
+}
+
+// Like c.CacheFuncJSONEncoded, but the caller stops waiting for 'f' when ctx is done (e.g. its client
+// has disconnected), and returns ctx.Err().
+//
+// 'f' isn't called by ctx, since its value is shared with concurrent misses on the same key and
+// refreshes stale values in background: the context of 'f' is canceled only when all of the callers
+// which wait for it are done, and background refreshes are never canceled.
+func (c *Cache) CacheFuncJSONEncodedContext(
+	ctx context.Context, apikey APICacheKey, key string, accept []Codec, f func(ctx context.Context) (interface{}, error),
+) ([]byte, Codec, State, error) {
+	return c.lookup(ctx, apikey, key, accept, jsonFetchFunc(apikey, f))
+}
+
+func withoutContext(f func() (interface{}, error)) func(context.Context) (interface{}, error) {
+	return func(context.Context) (interface{}, error) { return f() }
+}
+
+func jsonFetchFunc(apikey APICacheKey, f func(ctx context.Context) (interface{}, error)) fetchFunc {
+	return func(ctx context.Context) ([]byte, int64, error) {
+		valueInterface, err := f(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		value, err := json.Marshal(valueInterface)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		cfg := apikey.TTL()
+
+		ttl := cfg.ExtraTTL
+		if cfg.NegativeTTL > 0 && isEmpty(valueInterface) {
+			ttl = cfg.NegativeTTL
+		} else if apikey.Policy != nil {
+			ttl = apikey.Policy(valueInterface, ttl)
+		}
+
+		return value, ttl, nil
+	}
 }
 
 // Expiration Machine - deletes values which are expired.
@@ -163,17 +635,12 @@ func (e *ExpirationMachine) closeChannel() {
 	}
 }
 
-func (e *ExpirationMachine) deleteExpiredValues(expireAfterSeconds int64) (err error, exit bool) {
+func (e *ExpirationMachine) deleteExpiredValues() (err error, exit bool) {
 	if e.ActiveCache.Closed {
 		return errors.New("cache is closed"), true
 	}
 
-	keys, err := e.ActiveCache.driver.SelectExpiredValues(expireAfterSeconds)
-	if err != nil {
-		return err, false
-	}
-
-	i, err := e.ActiveCache.driver.DeleteMany(keys)
+	i, err := e.ActiveCache.DeleteExpired()
 	if err != nil {
 		return err, false
 	}
@@ -189,7 +656,7 @@ func (e *ExpirationMachine) Start(interval time.Duration) error {
 		return errors.New("expiration machine already running")
 	}
 
-	err, _ := e.deleteExpiredValues(0)
+	err, _ := e.deleteExpiredValues()
 	if err != nil {
 		if e.ActiveCache.Logger != nil {
 			e.ActiveCache.Logger.Log(logging.LEVEL_ERROR, "ExpirationMachine: while deleting: %s", err.Error())
@@ -208,7 +675,7 @@ func (e *ExpirationMachine) Start(interval time.Duration) error {
 		for {
 			select {
 			case <-ticktack.C:
-				err, exit := e.deleteExpiredValues(0)
+				err, exit := e.deleteExpiredValues()
 				if err != nil {
 					if e.ActiveCache.Logger != nil {
 						e.ActiveCache.Logger.Log(logging.LEVEL_ERROR, "ExpirationMachine: while deleting: %s", err.Error())
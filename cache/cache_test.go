@@ -0,0 +1,395 @@
+package cache_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+)
+
+func TestCacheFuncJSONCoalescing(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+
+	start := make(chan struct{})
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			data, _, err := c.CacheFuncJSON(cache.MATCH_INFO, "1", func() (interface{}, error) {
+				calls.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return []int{1, 2, 3}, nil
+			})
+			if err != nil || string(data) != "[1,2,3]" {
+				t.Errorf("unexpected result: %q, %v", data, err)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("upstream called %d times", n)
+	}
+
+	if n := c.Coalesced(); n != 19 {
+		t.Fatalf("unexpected coalesced count: %d", n)
+	}
+}
+
+func TestCacheFuncJSON(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	calls := 0
+	f := func() (interface{}, error) {
+		calls++
+		return "value", nil
+	}
+
+	for i := 0; i < 2; i++ {
+		data, ok, err := c.CacheFuncJSON(apikey, "1", f)
+		if err != nil || !ok || string(data) != `"value"` || calls != 1 {
+			t.Fatalf("unexpected result: %q, %v, %v, calls=%d", data, ok, err, calls)
+		}
+	}
+
+	data, ok, err := c.CacheFuncJSON(apikey, "2", func() (interface{}, error) {
+		return nil, errors.New("upstream is down")
+	})
+	if err == nil || ok || data != nil {
+		t.Fatalf("unexpected result: %q, %v, %v", data, ok, err)
+	}
+}
+
+func TestCacheFuncJSONStaleIfError(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 0, StaleIfError: 60}, nil)
+
+	_, state, _ := c.CacheFuncJSONState(apikey, "1", func() (interface{}, error) { return "old", nil })
+	if state != cache.STATE_MISS {
+		t.Fatalf("unexpected state: %s", state)
+	}
+
+	data, state, err := c.CacheFuncJSONState(apikey, "1", func() (interface{}, error) {
+		return nil, errors.New("upstream is down")
+	})
+	if err != nil || state != cache.STATE_STALE || string(data) != `"old"` {
+		t.Fatalf("unexpected result: %q, %s, %v", data, state, err)
+	}
+
+	data, state, _ = c.CacheFuncJSONState(apikey, "1", func() (interface{}, error) { return "new", nil })
+	if state != cache.STATE_MISS || string(data) != `"new"` {
+		t.Fatalf("unexpected result: %q, %s", data, state)
+	}
+}
+
+func TestCacheFuncJSONNegative(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60, NegativeTTL: 60}, nil)
+
+	var calls int
+	f := func() (interface{}, error) {
+		calls++
+		return nil, &api.StatusCodeError{Code: 404, Msg: "not found"}
+	}
+
+	c.CacheFuncJSON(apikey, "1", f)
+	data, state, err := c.CacheFuncJSONState(apikey, "1", f)
+
+	statusErr, ok := err.(*api.StatusCodeError)
+	if !ok || statusErr.Code != 404 || statusErr.Msg != "not found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data != nil || state != cache.STATE_HIT || calls != 1 {
+		t.Fatalf("unexpected result: %q, %s, calls=%d", data, state, calls)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	driver, _ := memory.Connect(0, 0)
+	c, err := cache.NewCache(driver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+	value := []byte(strings.Repeat(`{"id":1,"name":"kickcore"},`, 64))
+
+	// stored by older versions, without header
+	driver.Insert(apikey.Key+"legacy", []byte(`[1,2,3]`), time.Now().Unix()+60)
+
+	for _, name := range []string{"none", "deflate", "gzip", "brotli", "zstd"} {
+		codec, err := cache.ParseCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Codec = codec
+		c.Insert(apikey, name, value)
+
+		if size, _ := c.Size(); codec != cache.CODEC_NONE && size >= int64(len(value)) {
+			t.Errorf("%s: value isn't compressed", name)
+		}
+
+		if data, _ := c.Select(apikey, name); !bytes.Equal(data, value) {
+			t.Errorf("%s: unexpected value: %q", name, data)
+		}
+
+		if data, _ := c.Select(apikey, "legacy"); string(data) != "[1,2,3]" {
+			t.Errorf("%s: unexpected legacy value: %q", name, data)
+		}
+
+		c.Delete(apikey, name)
+	}
+
+	if _, err := cache.ParseCodec("lzma"); err == nil {
+		t.Fatal("expected error for unknown codec")
+	}
+}
+
+func TestCacheFuncJSONEncoded(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	c.Codec = cache.CODEC_ZSTD
+	c.Encodings = []cache.Codec{cache.CODEC_GZIP}
+
+	value := strings.Repeat("kickcore", 64)
+	f := func() (interface{}, error) { return value, nil }
+
+	data, codec, state, err := c.CacheFuncJSONEncoded(apikey, "1", []cache.Codec{cache.CODEC_GZIP}, f)
+	if err != nil || state != cache.STATE_MISS || codec != cache.CODEC_NONE {
+		t.Fatalf("unexpected result: %v, %v, %v", codec, state, err)
+	}
+
+	expected := append([]byte{}, data...)
+
+	data, codec, state, _ = c.CacheFuncJSONEncoded(apikey, "1", []cache.Codec{cache.CODEC_BROTLI, cache.CODEC_GZIP}, f)
+	if state != cache.STATE_HIT || codec != cache.CODEC_GZIP {
+		t.Fatalf("unexpected result: %v, %v", codec, state)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := io.ReadAll(r); !bytes.Equal(plain, expected) {
+		t.Fatalf("unexpected gzip variant: %q", plain)
+	}
+
+	// zstd is the codec of value itself
+	_, codec, _, _ = c.CacheFuncJSONEncoded(apikey, "1", []cache.Codec{cache.CODEC_ZSTD}, f)
+	if codec != cache.CODEC_ZSTD {
+		t.Fatalf("unexpected codec: %v", codec)
+	}
+
+	data, state, _ = c.CacheFuncJSONState(apikey, "1", f)
+	if state != cache.STATE_HIT || !bytes.Equal(data, expected) {
+		t.Fatalf("unexpected plain value: %q", data)
+	}
+}
+
+func TestCacheFuncJSONEncodedContext(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	started := make(chan struct{}, 1)
+	canceled := make(chan struct{})
+
+	f := func(ctx context.Context) (interface{}, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+
+	errs := make(chan error, 2)
+	go func() {
+		_, _, _, err := c.CacheFuncJSONEncodedContext(ctx1, apikey, "1", nil, f)
+		errs <- err
+	}()
+	<-started
+
+	go func() {
+		_, _, _, err := c.CacheFuncJSONEncodedContext(ctx2, apikey, "1", nil, f)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the call is shared with the second caller
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-canceled:
+		t.Fatal("shared call is canceled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call isn't canceled after all callers have left")
+	}
+
+	// canceled call isn't joined
+	data, _, state, err := c.CacheFuncJSONEncodedContext(
+		context.Background(), apikey, "1", nil, func(context.Context) (interface{}, error) { return 1, nil },
+	)
+	if err != nil || state != cache.STATE_MISS || string(data) != "1" {
+		t.Fatalf("unexpected result: %q, %v, %v", data, state, err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+	src.Insert(cache.MATCH_INFO, "1", []byte(`{"id":1}`)) // zero TTL; expired
+	src.Insert(apikey, "1", []byte(`{"id":1}`))
+	src.Insert(apikey, "2", []byte(`{"id":2}`))
+
+	var buf bytes.Buffer
+	if n, err := src.Export(&buf); err != nil || n != 2 {
+		t.Fatalf("unexpected export result: %d, %v", n, err)
+	}
+
+	dst, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if inserted, skipped, err := dst.Import(&buf); err != nil || inserted != 2 || skipped != 0 {
+		t.Fatalf("unexpected import result: %d, %d, %v", inserted, skipped, err)
+	}
+
+	if data, _ := dst.Select(apikey, "2"); string(data) != `{"id":2}` {
+		t.Fatalf("unexpected value: %q", data)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	c, err := cache.NewCache(cache.Open("memory://?max=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil)
+	c.Insert(apikey, "1", []byte("1"))
+	c.Insert(apikey, "2", []byte("2"))
+
+	if n, _ := c.Len(); n != 1 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+
+	for _, dsn := range []string{"unknown://", "memory://?max=x", "memory://?unknown=1", "memory"} {
+		if _, err := cache.Open(dsn); err == nil {
+			t.Fatalf("expected error for %q", dsn)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	driver, _ := memory.Connect(0, 0)
+	c, err := cache.NewCache(driver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	v1 := cache.NewAPICacheKey("t", cache.TTLConfig{ExtraTTL: 60}, nil).WithVersion(1)
+	v2 := v1.WithVersion(2)
+
+	c.Insert(v1, "1", []byte(`{"id":1}`))
+	if data, _ := c.Select(v2, "1"); data != nil {
+		t.Fatalf("value of older version is selected: %q", data)
+	}
+
+	calls := 0
+	data, state, _ := c.CacheFuncState(v2, "1", func() ([]byte, error) {
+		calls++
+		return []byte(`{"id":1,"name":"kickcore"}`), nil
+	})
+	if state != cache.STATE_MISS || calls != 1 || string(data) != `{"id":1,"name":"kickcore"}` {
+		t.Fatalf("unexpected result: %q, %s, calls=%d", data, state, calls)
+	}
+
+	// stored by an older version of MATCH_INFO (unversioned)
+	c.DeleteAll(v1)
+	date := time.Now().Unix() + 60
+	driver.Insert(cache.MATCH_INFO.Key+"123", []byte(`{"id":123}`), date)
+	driver.Insert(cache.MATCH_INFO.Key+"@v1:123", []byte(`{"id":123}`), date)
+
+	if n, err := c.DeleteOldVersions(); err != nil || n != 1 {
+		t.Fatalf("unexpected result of DeleteOldVersions: %d, %v", n, err)
+	}
+
+	if keys, _ := c.Keys(cache.MATCH_INFO); len(keys) != 1 || keys[0].Key != "123" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
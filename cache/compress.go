@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codec of cached values
+type Codec byte
+
+const (
+	CODEC_NONE Codec = iota
+	CODEC_DEFLATE
+	CODEC_GZIP
+	CODEC_BROTLI
+	CODEC_ZSTD
+)
+
+// Values smaller than this size are not compressed
+const compressMinSize = 256
+
+var codecNames = map[Codec]string{
+	CODEC_NONE:    "none",
+	CODEC_DEFLATE: "deflate",
+	CODEC_GZIP:    "gzip",
+	CODEC_BROTLI:  "brotli",
+	CODEC_ZSTD:    "zstd",
+}
+
+func (c Codec) String() string {
+	if name, ok := codecNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseCodec returns the codec by its name: "none", "deflate", "gzip", "brotli" or "zstd".
+func ParseCodec(name string) (Codec, error) {
+	if name == "" {
+		return CODEC_NONE, nil
+	}
+
+	for codec, codecName := range codecNames {
+		if codecName == name {
+			return codec, nil
+		}
+	}
+
+	return CODEC_NONE, errors.New("unknown compression codec: '" + name + "'")
+}
+
+var (
+	flateWriters  = sync.Pool{New: func() interface{} { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriter(nil) }}
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// resetWriter is implemented by compression writers
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func compressWith(pool *sync.Pool, value []byte) ([]byte, error) {
+	w := pool.Get().(resetWriter)
+	defer pool.Put(w)
+
+	var buf bytes.Buffer
+	w.Reset(&buf)
+
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compress compresses the value by codec.
+func compress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CODEC_NONE:
+		return value, nil
+	case CODEC_DEFLATE:
+		return compressWith(&flateWriters, value)
+	case CODEC_GZIP:
+		return compressWith(&gzipWriters, value)
+	case CODEC_BROTLI:
+		return compressWith(&brotliWriters, value)
+	case CODEC_ZSTD:
+		encoder, _ := zstdCodec()
+		return encoder.EncodeAll(value, nil), nil
+	}
+
+	return nil, errors.New("unknown compression codec")
+}
+
+// decompress decompresses the value which is compressed by codec.
+func decompress(codec Codec, value []byte) ([]byte, error) {
+	var r io.Reader
+
+	switch codec {
+	case CODEC_NONE:
+		return value, nil
+	case CODEC_DEFLATE:
+		fr := flate.NewReader(bytes.NewReader(value))
+		defer fr.Close()
+		r = fr
+	case CODEC_GZIP:
+		gr, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case CODEC_BROTLI:
+		r = brotli.NewReader(bytes.NewReader(value))
+	case CODEC_ZSTD:
+		_, decoder := zstdCodec()
+		return decoder.DecodeAll(value, nil)
+	default:
+		return nil, errors.New("unknown compression codec")
+	}
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,160 @@
+package cache
+
+import "encoding/binary"
+
+// Stored values are prefixed by a small header:
+//
+//	[0x00 marker][1 byte version][1 byte flags][1 byte codec][8 bytes fresh-until unix time]
+//
+// JSON values never start with 0x00, so values stored by older versions
+// (without header) are still readable. Version 2 headers have no codec byte.
+//
+// If entryVariants flag is set, the header is followed by the compressed variants
+// of the value:
+//
+//	[1 byte count]([1 byte codec][4 bytes length][variant])...
+const (
+	entryMarker     byte = 0x00
+	entryVersion    byte = 3
+	entryHeaderSize      = 12
+
+	entryVersion2    byte = 2
+	entryHeader2Size      = 11
+)
+
+// entry flags
+const (
+	// value is a JSON encoded *api.StatusCodeError
+	entryNegative byte = 1 << iota
+
+	// value has compressed variants
+	entryVariants
+)
+
+// Compressed variant of an entry value
+type variant struct {
+	codec Codec
+	value []byte
+}
+
+type entry struct {
+	// Unix time until which the value is fresh; zero means unknown (stored without header).
+	freshUntil int64
+
+	flags    byte
+	codec    Codec
+	value    []byte
+	variants []variant
+}
+
+func encodeEntry(e entry) []byte {
+	size := entryHeaderSize + len(e.value)
+	if len(e.variants) > 0 {
+		e.flags |= entryVariants
+		size++
+		for _, v := range e.variants {
+			size += 5 + len(v.value)
+		}
+	} else {
+		e.flags &^= entryVariants
+	}
+
+	b := make([]byte, entryHeaderSize, size)
+	b[0] = entryMarker
+	b[1] = entryVersion
+	b[2] = e.flags
+	b[3] = byte(e.codec)
+	binary.BigEndian.PutUint64(b[4:entryHeaderSize], uint64(e.freshUntil))
+
+	if len(e.variants) > 0 {
+		b = append(b, byte(len(e.variants)))
+		for _, v := range e.variants {
+			b = append(b, byte(v.codec))
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v.value)))
+			b = append(b, v.value...)
+		}
+	}
+
+	return append(b, e.value...)
+}
+
+// decodeEntry returns false if b has header with unknown version.
+// The value of returned entry is still compressed by its codec.
+func decodeEntry(b []byte) (entry, bool) {
+	if len(b) == 0 || b[0] != entryMarker {
+		return entry{value: b}, true
+	}
+
+	if len(b) < 2 {
+		return entry{}, false
+	}
+
+	switch {
+	case b[1] == entryVersion && len(b) >= entryHeaderSize:
+		e := entry{
+			freshUntil: int64(binary.BigEndian.Uint64(b[4:entryHeaderSize])),
+			flags:      b[2],
+			codec:      Codec(b[3]),
+		}
+
+		b = b[entryHeaderSize:]
+		if e.flags&entryVariants != 0 {
+			if len(b) < 1 {
+				return entry{}, false
+			}
+
+			n := int(b[0])
+			b = b[1:]
+
+			e.variants = make([]variant, 0, n)
+			for i := 0; i < n; i++ {
+				if len(b) < 5 {
+					return entry{}, false
+				}
+
+				size := int(binary.BigEndian.Uint32(b[1:5]))
+				if len(b)-5 < size {
+					return entry{}, false
+				}
+
+				e.variants = append(e.variants, variant{codec: Codec(b[0]), value: b[5 : 5+size]})
+				b = b[5+size:]
+			}
+		}
+
+		e.value = b
+		return e, true
+
+	case b[1] == entryVersion2 && len(b) >= entryHeader2Size:
+		return entry{
+			freshUntil: int64(binary.BigEndian.Uint64(b[3:entryHeader2Size])),
+			flags:      b[2],
+			value:      b[entryHeader2Size:],
+		}, true
+	}
+
+	return entry{}, false
+}
+
+// body returns the value of e encoded by the first codec of accept which e has
+// (as its own codec or as a variant); otherwise returns the decompressed value.
+func (e *entry) body(accept []Codec) ([]byte, Codec, error) {
+	for _, codec := range accept {
+		if codec == CODEC_NONE {
+			continue
+		}
+
+		if e.codec == codec {
+			return e.value, codec, nil
+		}
+
+		for _, v := range e.variants {
+			if v.codec == codec {
+				return v.value, codec, nil
+			}
+		}
+	}
+
+	value, err := decompress(e.codec, e.value)
+	return value, CODEC_NONE, err
+}
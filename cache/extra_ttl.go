@@ -2,64 +2,117 @@ package cache
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/awolverp/kickcore/logging"
 )
 
 func GenerateKey(s ...string) string {
 	return strings.Join(s, "-")
 }
 
-type APICacheKey struct {
-	Key      string
+// TTL configuration of an APICacheKey
+type TTLConfig struct {
+	// Seconds that the value is fresh
 	ExtraTTL int64
+
+	// Seconds after ExtraTTL that the stale value is returned immediately
+	// and refreshed in background
+	StaleWhileRevalidate int64
+
+	// Seconds after ExtraTTL that the stale value is returned if fetching
+	// the new value fails
+	StaleIfError int64
+
+	// Seconds that the 4xx errors and empty results are cached
+	NegativeTTL int64
 }
 
-// Implemented for kickcore/api package
-var (
-	EMPTY_APIKEY APICacheKey = APICacheKey{Key: "", ExtraTTL: 0}
+type APICacheKey struct {
+	Key string
 
-	ADVANCED_SEARCH APICacheKey = APICacheKey{
-		Key: "0", ExtraTTL: 0,
-	}
+	// Schema version of the cached objects; bump it when the object (e.g. a struct of
+	// api/objects.go) changes, so values of older versions are ignored and deleted
+	// (see Cache.DeleteOldVersions). Zero means unversioned.
+	Version int
 
-	COMPETITION_STANDING_TABLE APICacheKey = APICacheKey{
-		Key: "1", ExtraTTL: 0,
-	}
+	// Chooses TTL by the fetched object instead of ExtraTTL; see TTLPolicy.
+	Policy TTLPolicy
 
-	COMPETITION_WEEKS APICacheKey = APICacheKey{
-		Key: "2", ExtraTTL: 0,
-	}
+	// shared between copies, so the TTL can be changed while the key is in use
+	ttl *atomic.Pointer[TTLConfig]
+}
 
-	COMPETITIONS_LIST APICacheKey = APICacheKey{
-		Key: "3", ExtraTTL: 0,
-	}
+func NewAPICacheKey(key string, c TTLConfig, policy TTLPolicy) APICacheKey {
+	apikey := APICacheKey{Key: key, Policy: policy, ttl: new(atomic.Pointer[TTLConfig])}
+	apikey.ttl.Store(&c)
+	return apikey
+}
 
-	MATCH_INFO APICacheKey = APICacheKey{
-		Key: "4", ExtraTTL: 0,
-	}
+// Returns a copy of the key with schema version v.
+func (k APICacheKey) WithVersion(v int) APICacheKey {
+	k.Version = v
+	return k
+}
 
-	MATCHES_BY_DATE APICacheKey = APICacheKey{
-		Key: "5", ExtraTTL: 0,
+// prefix returns the prefix of stored keys, e.g. "4@v1:" for version 1 of "4".
+func (k APICacheKey) prefix() string {
+	if k.Version == 0 {
+		return k.Key
 	}
+	return k.Key + "@v" + strconv.Itoa(k.Version) + ":"
+}
 
-	MATCHES_BY_WEEKNUMBER APICacheKey = APICacheKey{
-		Key: "6", ExtraTTL: 0,
+// Returns the current TTL configuration of the key. It's safe for concurrent use.
+func (k APICacheKey) TTL() TTLConfig {
+	if k.ttl == nil {
+		return TTLConfig{}
 	}
 
-	TRANSFERS APICacheKey = APICacheKey{
-		Key: "7", ExtraTTL: 0,
+	if c := k.ttl.Load(); c != nil {
+		return *c
 	}
+	return TTLConfig{}
+}
 
-	TRANSFERS_REGIONS APICacheKey = APICacheKey{
-		Key: "8", ExtraTTL: 0,
+// Changes the TTL configuration of the key (and all its copies). It's safe for concurrent use.
+func (k APICacheKey) SetTTL(c TTLConfig) {
+	if k.ttl != nil {
+		k.ttl.Store(&c)
 	}
+}
 
-	SEARCH APICacheKey = APICacheKey{
-		Key: "9", ExtraTTL: 0,
-	}
+// Implemented for kickcore/api package
+var (
+	EMPTY_APIKEY APICacheKey = NewAPICacheKey("", TTLConfig{}, nil)
+
+	ADVANCED_SEARCH APICacheKey = NewAPICacheKey("0", TTLConfig{}, nil).WithVersion(1)
+
+	COMPETITION_STANDING_TABLE APICacheKey = NewAPICacheKey("1", TTLConfig{}, StandingTablePolicy).WithVersion(1)
+
+	COMPETITION_WEEKS APICacheKey = NewAPICacheKey("2", TTLConfig{}, WeeksPolicy).WithVersion(1)
+
+	COMPETITIONS_LIST APICacheKey = NewAPICacheKey("3", TTLConfig{}, nil).WithVersion(1)
+
+	MATCH_INFO APICacheKey = NewAPICacheKey("4", TTLConfig{}, MatchPolicy).WithVersion(1)
+
+	MATCHES_BY_DATE APICacheKey = NewAPICacheKey("5", TTLConfig{}, MatchPolicy).WithVersion(1)
+
+	MATCHES_BY_WEEKNUMBER APICacheKey = NewAPICacheKey("6", TTLConfig{}, MatchPolicy).WithVersion(1)
+
+	TRANSFERS APICacheKey = NewAPICacheKey("7", TTLConfig{}, nil).WithVersion(1)
+
+	TRANSFERS_REGIONS APICacheKey = NewAPICacheKey("8", TTLConfig{}, nil).WithVersion(1)
+
+	SEARCH APICacheKey = NewAPICacheKey("9", TTLConfig{}, nil).WithVersion(1)
 )
 
 var mapVars = map[string](*APICacheKey){
@@ -75,45 +128,227 @@ var mapVars = map[string](*APICacheKey){
 	"SEARCH":                     &SEARCH,
 }
 
-func ReadExtraTTL(filename string) error {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return err
+// Returns the API key by its name (e.g. "MATCH_INFO").
+func APICacheKeyByName(name string) (APICacheKey, bool) {
+	apikey, ok := mapVars[name]
+	if !ok {
+		return EMPTY_APIKEY, false
+	}
+	return *apikey, true
+}
+
+// Returns the sorted names of API keys.
+func APICacheKeyNames() []string {
+	names := make([]string, 0, len(mapVars))
+	for name := range mapVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseSeconds parses non-negative integer (seconds) or duration string.
+func parseSeconds(obj interface{}) (int64, error) {
+	var d int64
+
+	switch objvalue := obj.(type) {
+	case float64:
+		d = int64(objvalue)
+
+	case string:
+		dur, err := time.ParseDuration(objvalue)
+		if err != nil {
+			return 0, err
+		}
+		d = int64(dur.Seconds())
+
+	default:
+		return 0, errors.New("must be integer or duration string")
 	}
 
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
+}
+
+// parseExtraTTL parses the content of extra TTL file.
+func parseExtraTTL(data []byte) (map[*APICacheKey]TTLConfig, error) {
 	var content map[string]interface{}
 
-	err = json.Unmarshal(data, &content)
+	err := json.Unmarshal(data, &content)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	result := make(map[*APICacheKey]TTLConfig, len(mapVars))
+
 	for key, value := range mapVars {
 		obj, ok := content[key]
 		if !ok {
+			result[value] = TTLConfig{}
 			continue
 		}
 
-		var d int64
+		var c TTLConfig
 
-		switch objvalue := obj.(type) {
-		case int:
-			d = int64(objvalue)
+		if fields, ok := obj.(map[string]interface{}); ok {
+			for name, field := range fields {
+				var d int64
 
-		case string:
-			var dur time.Duration
-			dur, err = time.ParseDuration(objvalue)
-			if err == nil {
-				d = int64(dur.Seconds())
+				d, err = parseSeconds(field)
+				if err != nil {
+					return nil, fmt.Errorf("expire ttl: cannot parse '%v' ('%s.%s'): %s", field, key, name, err.Error())
+				}
+
+				switch name {
+				case "ttl":
+					c.ExtraTTL = d
+				case "stale_while_revalidate":
+					c.StaleWhileRevalidate = d
+				case "stale_if_error":
+					c.StaleIfError = d
+				case "negative_ttl":
+					c.NegativeTTL = d
+				default:
+					return nil, fmt.Errorf("expire ttl: unknown field '%s.%s'", key, name)
+				}
+			}
+		} else {
+			c.ExtraTTL, err = parseSeconds(obj)
+			if err != nil {
+				return nil, fmt.Errorf("expire ttl: cannot parse '%v' ('%s'): %s", obj, key, err.Error())
 			}
 		}
 
-		if err != nil {
-			return fmt.Errorf("expire ttl: cannot parse '%v' ('%s'): %s", obj, key, err.Error())
+		result[value] = c
+	}
+
+	return result, nil
+}
+
+// ReadExtraTTL reads TTL of API keys from JSON file.
+// If the file is invalid (e.g. has a negative value), no TTL is changed.
+//
+// Value of each API key can be integer (seconds), duration string, or an object:
+//
+//	{"ttl": "1m", "stale_while_revalidate": "30s", "stale_if_error": "1h", "negative_ttl": "30s"}
+//
+// API keys which are not in the file have zero TTL.
+func ReadExtraTTL(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	table, err := parseExtraTTL(data)
+	if err != nil {
+		return err
+	}
+
+	for apikey, c := range table {
+		apikey.SetTTL(c)
+	}
+
+	return nil
+}
+
+// ExtraTTLWatcher reloads extra TTL file when it's modified.
+type ExtraTTLWatcher struct {
+	// Extra TTL filename
+	Filename string
+
+	Logger *logging.FileLogger
+
+	locker  sync.Mutex
+	modTime time.Time
+	pool    chan struct{}
+}
+
+func (w *ExtraTTLWatcher) log(level int, msg string, args ...interface{}) {
+	if w.Logger != nil {
+		w.Logger.Log(level, msg, args...)
+	}
+}
+
+// Reload reads the extra TTL file. If the file is invalid, the error is logged and
+// the last good configuration is kept.
+func (w *ExtraTTLWatcher) Reload() error {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+
+	return w.reload()
+}
+
+func (w *ExtraTTLWatcher) reload() error {
+	if info, err := os.Stat(w.Filename); err == nil {
+		w.modTime = info.ModTime()
+	}
+
+	err := ReadExtraTTL(w.Filename)
+	if err != nil {
+		w.log(logging.LEVEL_ERROR, "ExtraTTLWatcher: keeping last good config: %s", err.Error())
+		return err
+	}
+
+	w.log(logging.LEVEL_INFO, "ExtraTTLWatcher: '%s' reloaded", w.Filename)
+	return nil
+}
+
+func (w *ExtraTTLWatcher) check() {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+
+	info, err := os.Stat(w.Filename)
+	if err != nil {
+		w.log(logging.LEVEL_ERROR, "ExtraTTLWatcher: %s", err.Error())
+		return
+	}
+
+	if !info.ModTime().Equal(w.modTime) {
+		w.reload()
+	}
+}
+
+// Start checks the modification time of file after any interval and reloads it when it's changed.
+func (w *ExtraTTLWatcher) Start(interval time.Duration) error {
+	if w.pool != nil {
+		return errors.New("extra ttl watcher already running")
+	}
+
+	info, err := os.Stat(w.Filename)
+	if err != nil {
+		return err
+	}
+	w.modTime = info.ModTime()
+
+	w.pool = make(chan struct{})
+
+	go func(pool chan struct{}) {
+		ticktack := time.NewTicker(interval)
+		defer ticktack.Stop()
+
+		for {
+			select {
+			case <-ticktack.C:
+				w.check()
+			case <-pool:
+				return
+			}
 		}
+	}(w.pool)
 
-		(*value).ExtraTTL = d
+	return nil
+}
+
+func (w *ExtraTTLWatcher) Stop() error {
+	if w.pool == nil {
+		return errors.New("extra ttl watcher isn't started")
 	}
 
+	close(w.pool)
+	w.pool = nil
 	return nil
 }
+
+func (w *ExtraTTLWatcher) IsStarted() bool { return w.pool != nil }
@@ -0,0 +1,140 @@
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+)
+
+// writeExtraTTL writes data into the expire ttl file, and restores TTLs of API keys after the test.
+func writeExtraTTL(t *testing.T, filename, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ttls := make(map[string]cache.TTLConfig)
+	for _, name := range cache.APICacheKeyNames() {
+		apikey, _ := cache.APICacheKeyByName(name)
+		ttls[name] = apikey.TTL()
+	}
+
+	t.Cleanup(func() {
+		for name, c := range ttls {
+			apikey, _ := cache.APICacheKeyByName(name)
+			apikey.SetTTL(c)
+		}
+	})
+}
+
+func TestReadExtraTTL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "extra_ttl.json")
+	writeExtraTTL(t, filename, `{
+		"MATCH_INFO": 60,
+		"TRANSFERS": "2m",
+		"SEARCH": {"ttl": "1m", "stale_while_revalidate": 30, "stale_if_error": "1h", "negative_ttl": "10s"}
+	}`)
+
+	// keys which are missing from file are reset
+	cache.COMPETITIONS_LIST.SetTTL(cache.TTLConfig{ExtraTTL: 99})
+
+	if err := cache.ReadExtraTTL(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]cache.TTLConfig{
+		"MATCH_INFO":        {ExtraTTL: 60},
+		"TRANSFERS":         {ExtraTTL: 120},
+		"SEARCH":            {ExtraTTL: 60, StaleWhileRevalidate: 30, StaleIfError: 3600, NegativeTTL: 10},
+		"COMPETITIONS_LIST": {},
+	}
+
+	for name, c := range expected {
+		apikey, _ := cache.APICacheKeyByName(name)
+		if apikey.TTL() != c {
+			t.Errorf("unexpected TTL of %s: %+v", name, apikey.TTL())
+		}
+	}
+}
+
+func TestReadExtraTTLInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "extra_ttl.json")
+
+	for _, data := range []string{
+		`not json`,
+		`[60]`,
+		`{"MATCH_INFO": -1}`,
+		`{"MATCH_INFO": "-1m"}`,
+		`{"MATCH_INFO": {"ttl": 60, "stale_if_error": -5}}`,
+		`{"MATCH_INFO": "1 minute"}`,
+		`{"MATCH_INFO": true}`,
+		`{"MATCH_INFO": {"unknown": 60}}`,
+	} {
+		writeExtraTTL(t, filename, data)
+		cache.MATCH_INFO.SetTTL(cache.TTLConfig{ExtraTTL: 99})
+		cache.TRANSFERS.SetTTL(cache.TTLConfig{ExtraTTL: 99})
+
+		if err := cache.ReadExtraTTL(filename); err == nil {
+			t.Errorf("invalid file is accepted: %s", data)
+		}
+
+		// no TTL is changed
+		if cache.MATCH_INFO.TTL().ExtraTTL != 99 || cache.TRANSFERS.TTL().ExtraTTL != 99 {
+			t.Errorf("TTL is changed by invalid file: %s", data)
+		}
+	}
+}
+
+func TestExtraTTLWatcher(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "extra_ttl.json")
+	writeExtraTTL(t, filename, `{"MATCH_INFO": 60}`)
+
+	if err := cache.ReadExtraTTL(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &cache.ExtraTTLWatcher{Filename: filename}
+	if err := w.Start(time.Millisecond * 10); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// modTime is changed explicitly, since writes may happen in the same tick of file system clock
+	modified := time.Now()
+	rewrite := func(data string) {
+		modified = modified.Add(time.Second)
+		os.WriteFile(filename, []byte(data), 0644)
+		os.Chtimes(filename, modified, modified)
+	}
+
+	waitTTL := func(expected int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(time.Second)
+		for cache.MATCH_INFO.TTL().ExtraTTL != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected TTL: %d != %d", cache.MATCH_INFO.TTL().ExtraTTL, expected)
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+	}
+
+	rewrite(`{"MATCH_INFO": 120}`)
+	waitTTL(120)
+
+	// the last good config is kept
+	rewrite(`{"MATCH_INFO": -1}`)
+	time.Sleep(time.Millisecond * 50)
+	waitTTL(120)
+
+	if err := w.Reload(); err == nil {
+		t.Fatal("invalid file is reloaded")
+	}
+
+	rewrite(`{"MATCH_INFO": 30}`)
+	waitTTL(30)
+}
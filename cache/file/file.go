@@ -0,0 +1,722 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/logging"
+)
+
+// The file starts with fileMagic, and is followed by records:
+//
+//	[4 bytes crc32][1 byte type][4 bytes key length][4 bytes value length][8 bytes expires at][key][value]
+//
+// The checksum covers the rest of record. Records are only appended; the last record of a key
+// wins, and delete records (tombstones) remove the key. A torn record at the end of file (e.g.
+// after a crash) is truncated on startup; corrupted records in the middle of file are skipped
+// (the valid records after them are kept), and the file is compacted.
+var fileMagic = []byte("KICKCACHE\x01")
+
+const (
+	recordPut    byte = 1
+	recordDelete byte = 2
+
+	recordHeaderSize = 4 + 1 + 4 + 4 + 8
+
+	// Limits of records; larger lengths mean the record is corrupted
+	maxKeySize   = 1 << 20
+	maxValueSize = 1 << 30
+)
+
+// Files which are smaller than this size are not compacted periodically.
+const minCompactSize = 1 << 20
+
+var errLocked = errors.New("file: cache file is used by another process")
+
+type Config struct {
+	// Path of cache file
+	Path string
+
+	// Compacts the file after every interval if its garbage ratio is reached;
+	// zero disables periodic compaction ( see FileCacheDriver.Compact )
+	CompactInterval time.Duration
+
+	// Minimum ratio of garbage (replaced and deleted records) to file size for periodic
+	// compaction ( default 0.5 )
+	CompactRatio float64
+
+	// Calls fsync after every write; otherwise, the last writes may be lost on system crash
+	// (but the file is still recovered)
+	Sync bool
+
+	// Logs the records which are dropped on recovery, and errors of periodic compaction
+	// ( default no logging; see SetLogger )
+	Logger *logging.FileLogger
+}
+
+type indexEntry struct {
+	// Offset and size of record in file
+	offset int64
+	size   int64
+
+	keySize   int64
+	valueSize int64
+	expiresAt int64
+}
+
+func (e *indexEntry) valueOffset() int64 { return e.offset + recordHeaderSize + e.keySize }
+
+// FileCacheDriver is a persistent cache driver in pure Go (without cgo).
+//
+// Values are appended to a log file, and an in-memory index keeps their positions;
+// values are read from file on select. Replaced and deleted values are removed from file
+// by compaction. The file is locked while it's open, so it can't be used by another process
+// (or driver) at the same time.
+type FileCacheDriver struct {
+	locker sync.RWMutex
+
+	file  *os.File
+	path  string
+	index map[string]*indexEntry
+
+	// End of file, and size of replaced and deleted records
+	size    int64
+	garbage int64
+
+	compactInterval time.Duration
+	compactRatio    float64
+	sync            bool
+	logger          *logging.FileLogger
+
+	pool chan struct{}
+}
+
+// appendRecord appends the record to buf.
+func appendRecord(buf []byte, typ byte, key string, value []byte, expiresAt int64) []byte {
+	start := len(buf)
+
+	buf = append(buf, 0, 0, 0, 0, typ)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiresAt))
+	buf = append(buf, key...)
+	buf = append(buf, value...)
+
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+func (db *FileCacheDriver) log(level int, msg string, args ...interface{}) {
+	if db.logger != nil {
+		db.logger.Log(level, msg, args...)
+	}
+}
+
+// SetLogger sets the logger of driver (see Config.Logger); it must be called before Init.
+func (db *FileCacheDriver) SetLogger(logger *logging.FileLogger) { db.logger = logger }
+
+// parseHeader returns the type, key size, value size and expiration time of the record
+// header; ok is false if the header is invalid.
+func parseHeader(header []byte) (typ byte, keySize, valueSize, expiresAt int64, ok bool) {
+	typ = header[4]
+	keySize = int64(binary.BigEndian.Uint32(header[5:9]))
+	valueSize = int64(binary.BigEndian.Uint32(header[9:13]))
+	expiresAt = int64(binary.BigEndian.Uint64(header[13:21]))
+
+	switch typ {
+	case recordPut:
+		ok = keySize <= maxKeySize && valueSize <= maxValueSize
+	case recordDelete:
+		ok = keySize <= maxKeySize && valueSize == 0 && expiresAt == 0
+	}
+	return
+}
+
+// validRecord reports whether a valid record starts at offset.
+func (db *FileCacheDriver) validRecord(offset, end int64) bool {
+	header := make([]byte, recordHeaderSize)
+	if _, err := db.file.ReadAt(header, offset); err != nil {
+		return false
+	}
+
+	_, keySize, valueSize, _, ok := parseHeader(header)
+	if !ok || offset+recordHeaderSize+keySize+valueSize > end {
+		return false
+	}
+
+	body := make([]byte, keySize+valueSize)
+	if _, err := db.file.ReadAt(body, offset+recordHeaderSize); err != nil {
+		return false
+	}
+
+	return crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, body) == binary.BigEndian.Uint32(header[0:4])
+}
+
+// nextRecord returns the offset of the first valid record after offset, or end if there's none.
+func (db *FileCacheDriver) nextRecord(offset, end int64) int64 {
+	const window = 1 << 16
+
+	// windows overlap by a header, so headers on the boundaries aren't missed
+	buf := make([]byte, window+recordHeaderSize)
+
+	for start := offset + 1; start+recordHeaderSize <= end; start += window {
+		n, _ := db.file.ReadAt(buf, start)
+
+		for i := 0; i+recordHeaderSize <= n && i < window; i++ {
+			_, keySize, valueSize, _, ok := parseHeader(buf[i : i+recordHeaderSize])
+			if ok && start+int64(i)+recordHeaderSize+keySize+valueSize <= end && db.validRecord(start+int64(i), end) {
+				return start + int64(i)
+			}
+		}
+	}
+
+	return end
+}
+
+// recover reads the file and builds the index. A torn tail is truncated, and corrupted records
+// are skipped; the dropped bytes are logged.
+func (db *FileCacheDriver) recover() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	db.index = make(map[string]*indexEntry)
+	db.garbage = 0
+
+	if info.Size() == 0 {
+		if _, err = db.file.WriteAt(fileMagic, 0); err != nil {
+			return err
+		}
+		db.size = int64(len(fileMagic))
+		return db.file.Sync()
+	}
+
+	end := info.Size()
+
+	magic := make([]byte, len(fileMagic))
+	if _, err = db.file.ReadAt(magic, 0); err != nil || string(magic) != string(fileMagic) {
+		return errors.New("file: '" + db.path + "' isn't a cache file")
+	}
+
+	offset := int64(len(fileMagic))
+	header := make([]byte, recordHeaderSize)
+	var body []byte
+
+	// bytes of corrupted records which are skipped
+	var skipped int64
+
+	r := bufio.NewReaderSize(io.NewSectionReader(db.file, offset, end-offset), 1<<16)
+
+	for offset < end {
+		var typ byte
+		var keySize, valueSize, expiresAt int64
+		var ok bool
+
+		if _, err = io.ReadFull(r, header); err == nil {
+			typ, keySize, valueSize, expiresAt, ok = parseHeader(header)
+		}
+
+		if ok {
+			if int64(cap(body)) < keySize+valueSize {
+				body = make([]byte, keySize+valueSize)
+			}
+			body = body[:keySize+valueSize]
+
+			if _, err = io.ReadFull(r, body); err != nil {
+				ok = false
+			} else {
+				ok = crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, body) == binary.BigEndian.Uint32(header[0:4])
+			}
+		}
+
+		if !ok {
+			next := db.nextRecord(offset, end)
+			if next == end {
+				// torn tail
+				break
+			}
+
+			db.log(
+				logging.LEVEL_WARNING, "file: '%s': skipped %d bytes of corrupted records at offset %d",
+				db.path, next-offset, offset,
+			)
+
+			skipped += next - offset
+			offset = next
+			r.Reset(io.NewSectionReader(db.file, offset, end-offset))
+			continue
+		}
+
+		size := recordHeaderSize + keySize + valueSize
+		key := string(body[:keySize])
+
+		if old, ok := db.index[key]; ok {
+			db.garbage += old.size
+			delete(db.index, key)
+		}
+
+		if typ == recordPut {
+			db.index[key] = &indexEntry{
+				offset: offset, size: size, keySize: keySize, valueSize: valueSize, expiresAt: expiresAt,
+			}
+		} else {
+			db.garbage += size
+		}
+
+		offset += size
+	}
+
+	db.size = offset
+
+	if offset < end {
+		db.log(
+			logging.LEVEL_WARNING, "file: '%s': truncated %d bytes of torn records at offset %d", db.path, end-offset, offset,
+		)
+
+		if err = db.file.Truncate(offset); err != nil {
+			return err
+		}
+		if err = db.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if skipped > 0 {
+		// removes the corrupted records from file
+		db.garbage += skipped
+		return db.compact()
+	}
+
+	return nil
+}
+
+// write appends buf to file.
+func (db *FileCacheDriver) write(buf []byte) error {
+	if _, err := db.file.WriteAt(buf, db.size); err != nil {
+		// removes the partial record
+		db.file.Truncate(db.size)
+		return err
+	}
+
+	if db.sync {
+		if err := db.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	db.size += int64(len(buf))
+	return nil
+}
+
+func (db *FileCacheDriver) readValue(e *indexEntry) ([]byte, error) {
+	value := make([]byte, e.valueSize)
+	if _, err := db.file.ReadAt(value, e.valueOffset()); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (db *FileCacheDriver) Init() error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	// The temporary file of an interrupted compaction
+	os.Remove(db.path + ".compact")
+
+	if err := db.recover(); err != nil {
+		return err
+	}
+
+	if db.compactInterval > 0 && db.pool == nil {
+		db.pool = make(chan struct{})
+		go db.compactor(db.pool)
+	}
+
+	return nil
+}
+
+func (db *FileCacheDriver) PingContext(_ context.Context) error {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+
+	if db.file == nil {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (db *FileCacheDriver) Insert(key string, value []byte, expiresAt int64) (bool, error) {
+	if len(key) > maxKeySize || len(value) > maxValueSize {
+		return false, errors.New("file: key or value is too large")
+	}
+
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	old, ok := db.index[key]
+	if ok && old.expiresAt > time.Now().Unix() {
+		return false, nil
+	}
+
+	record := appendRecord(nil, recordPut, key, value, expiresAt)
+
+	offset := db.size
+	if err := db.write(record); err != nil {
+		return false, err
+	}
+
+	if ok {
+		db.garbage += old.size
+	}
+
+	db.index[key] = &indexEntry{
+		offset: offset, size: int64(len(record)),
+		keySize: int64(len(key)), valueSize: int64(len(value)), expiresAt: expiresAt,
+	}
+	return true, nil
+}
+
+func (db *FileCacheDriver) Select(key string, value *[]byte, expiresAt *int64) error {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+
+	e, ok := db.index[key]
+	if !ok || e.expiresAt <= time.Now().Unix() {
+		return nil
+	}
+
+	result, err := db.readValue(e)
+	if err != nil {
+		return err
+	}
+
+	*value = result
+	*expiresAt = e.expiresAt
+	return nil
+}
+
+func (db *FileCacheDriver) SelectExpired(now int64) ([]string, error) {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+
+	var keys []string
+	for key, e := range db.index {
+		if e.expiresAt <= now {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (db *FileCacheDriver) Delete(key string) (bool, error) {
+	n, err := db.DeleteMany([]string{key})
+	return n != 0, err
+}
+
+func (db *FileCacheDriver) DeleteMany(keys []string) (int64, error) {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	var buf []byte
+	var deleted []string
+
+	for _, key := range keys {
+		if _, ok := db.index[key]; ok {
+			buf = appendRecord(buf, recordDelete, key, nil, 0)
+			deleted = append(deleted, key)
+		}
+	}
+
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	// tombstones are written at once
+	if err := db.write(buf); err != nil {
+		return 0, err
+	}
+
+	for _, key := range deleted {
+		db.garbage += db.index[key].size + recordHeaderSize + int64(len(key))
+		delete(db.index, key)
+	}
+
+	return int64(len(deleted)), nil
+}
+
+func (db *FileCacheDriver) Range(prefix string, f func(key string, value []byte, expiresAt int64) bool) error {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+
+	for key, e := range db.index {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		value, err := db.readValue(e)
+		if err != nil {
+			return err
+		}
+
+		if !f(key, value, e.expiresAt) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// RangeKeys ranges over the in-memory index; the file isn't read.
+func (db *FileCacheDriver) RangeKeys(prefix string, f func(key string, expiresAt int64) bool) error {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+
+	for key, e := range db.index {
+		if strings.HasPrefix(key, prefix) && !f(key, e.expiresAt) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (db *FileCacheDriver) Len() (int64, error) {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+	return int64(len(db.index)), nil
+}
+
+// Returns the size of cache file in bytes
+func (db *FileCacheDriver) Size() (int64, error) {
+	db.locker.RLock()
+	defer db.locker.RUnlock()
+	return db.size, nil
+}
+
+// Compact rewrites the file without replaced, deleted and expired values.
+//
+// The values are written into a temporary file which replaces the file atomically,
+// so the file is never lost if compaction is interrupted.
+func (db *FileCacheDriver) Compact() error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+	return db.compact()
+}
+
+func (db *FileCacheDriver) compact() error {
+	// the compactor may tick after close
+	if db.file == nil {
+		return os.ErrClosed
+	}
+
+	tmpPath := db.path + ".compact"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// the file which replaces the locked file is locked before
+	if err = lockFile(tmp); err != nil {
+		return fail(err)
+	}
+
+	w := bufio.NewWriterSize(tmp, 1<<16)
+	if _, err = w.Write(fileMagic); err != nil {
+		return fail(err)
+	}
+
+	index := make(map[string]*indexEntry, len(db.index))
+	offset := int64(len(fileMagic))
+	now := time.Now().Unix()
+
+	var record []byte
+
+	for key, e := range db.index {
+		if e.expiresAt <= now {
+			continue
+		}
+
+		value, err := db.readValue(e)
+		if err != nil {
+			return fail(err)
+		}
+
+		record = appendRecord(record[:0], recordPut, key, value, e.expiresAt)
+		if _, err = w.Write(record); err != nil {
+			return fail(err)
+		}
+
+		index[key] = &indexEntry{
+			offset: offset, size: int64(len(record)), keySize: e.keySize, valueSize: e.valueSize, expiresAt: e.expiresAt,
+		}
+		offset += int64(len(record))
+	}
+
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err = os.Rename(tmpPath, db.path); err != nil {
+		return fail(err)
+	}
+
+	// makes the rename durable
+	if dir, err := os.Open(filepath.Dir(db.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	db.file.Close()
+	db.file = tmp
+	db.index = index
+	db.size = offset
+	db.garbage = 0
+	return nil
+}
+
+func (db *FileCacheDriver) compactor(stop <-chan struct{}) {
+	ticktack := time.NewTicker(db.compactInterval)
+	defer ticktack.Stop()
+
+	for {
+		select {
+		case <-ticktack.C:
+			db.locker.Lock()
+			if db.file != nil && db.size >= minCompactSize && float64(db.garbage) >= float64(db.size)*db.compactRatio {
+				if err := db.compact(); err != nil {
+					db.log(logging.LEVEL_WARNING, "file: '%s': compaction failed: %s", db.path, err.Error())
+				}
+			}
+			db.locker.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (db *FileCacheDriver) Close() error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	if db.pool != nil {
+		close(db.pool)
+		db.pool = nil
+	}
+
+	if db.file == nil {
+		return nil
+	}
+
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+// Connect creates a file cache driver which compacts the file every 10 minutes.
+func Connect(path string) (cache.CacheDriver, error) {
+	return ConnectWithConfig(&Config{Path: path, CompactInterval: time.Minute * 10})
+}
+
+func ConnectWithConfig(c *Config) (cache.CacheDriver, error) {
+	if c == nil {
+		return nil, errors.New("argument (*Config) is nil")
+	}
+
+	if c.Path == "" {
+		return nil, errors.New("file: path is empty")
+	}
+
+	file, err := os.OpenFile(c.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(file); err != nil {
+		file.Close()
+		if err == errLocked {
+			return nil, errors.New("file: '" + c.Path + "' is used by another process")
+		}
+		return nil, err
+	}
+
+	db := new(FileCacheDriver)
+	db.file = file
+	db.path = c.Path
+	db.index = make(map[string]*indexEntry)
+	db.compactInterval = c.CompactInterval
+	db.compactRatio = c.CompactRatio
+	db.sync = c.Sync
+	db.logger = c.Logger
+
+	if db.compactRatio <= 0 {
+		db.compactRatio = 0.5
+	}
+
+	return db, nil
+}
+
+func init() { cache.Register("file", open) }
+
+// open creates the driver by DSN, e.g. "file:///var/kickcore.cache?compact=10m" (absolute path)
+// or "file://kickcore.cache" (relative path):
+//   - compact: interval of periodic compaction ( default 10m; "0" disables it ).
+//   - ratio: minimum garbage ratio for periodic compaction ( default 0.5 ).
+//   - sync: calls fsync after every write ( default 0 ).
+func open(dsn *url.URL) (cache.CacheDriver, error) {
+	if err := cache.CheckParams(dsn, "compact", "ratio", "sync"); err != nil {
+		return nil, err
+	}
+
+	c := Config{Path: dsn.Opaque, CompactInterval: time.Minute * 10}
+	if c.Path == "" {
+		c.Path = dsn.Host + dsn.Path
+	}
+
+	var err error
+	query := dsn.Query()
+
+	if value := query.Get("compact"); value != "" {
+		if value == "0" {
+			c.CompactInterval = 0
+		} else if c.CompactInterval, err = time.ParseDuration(value); err != nil {
+			return nil, errors.New("file: invalid 'compact' parameter: " + err.Error())
+		}
+	}
+
+	if value := query.Get("ratio"); value != "" {
+		if c.CompactRatio, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, errors.New("file: invalid 'ratio' parameter: " + err.Error())
+		}
+	}
+
+	if value := query.Get("sync"); value != "" {
+		if c.Sync, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.New("file: invalid 'sync' parameter: " + err.Error())
+		}
+	}
+
+	return ConnectWithConfig(&c)
+}
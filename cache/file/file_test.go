@@ -0,0 +1,264 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/file"
+	"github.com/awolverp/kickcore/logging"
+)
+
+func connect(t *testing.T, path string) cache.CacheDriver {
+	t.Helper()
+
+	db, err := file.ConnectWithConfig(&file.Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func selectValue(db cache.CacheDriver, key string) string {
+	var value []byte
+	var expiresAt int64
+	db.Select(key, &value, &expiresAt)
+	return string(value)
+}
+
+func TestInsertSelect(t *testing.T) {
+	db := connect(t, filepath.Join(t.TempDir(), "kickcore.cache"))
+
+	now := time.Now().Unix()
+
+	if ok, err := db.Insert("a", []byte("1"), now+60); !ok || err != nil {
+		t.Fatalf("unexpected result of Insert: %v, %v", ok, err)
+	}
+	if ok, _ := db.Insert("a", []byte("2"), now+60); ok {
+		t.Fatal("live value is replaced")
+	}
+
+	db.Insert("expired", []byte("1"), now-1)
+	if selectValue(db, "expired") != "" {
+		t.Fatal("expired value is selected")
+	}
+	if keys, _ := db.SelectExpired(now); len(keys) != 1 || keys[0] != "expired" {
+		t.Fatalf("unexpected expired keys: %q", keys)
+	}
+
+	if v := selectValue(db, "a"); v != "1" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+
+	if ok, err := db.Delete("a"); !ok || err != nil {
+		t.Fatalf("unexpected result of Delete: %v, %v", ok, err)
+	}
+	if selectValue(db, "a") != "" {
+		t.Fatal("deleted value is selected")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kickcore.cache")
+	date := time.Now().Unix() + 60
+
+	db := connect(t, path)
+	for i := 0; i < 10; i++ {
+		db.Insert(strconv.Itoa(i), []byte("value"+strconv.Itoa(i)), date)
+	}
+	db.DeleteMany([]string{"0", "1"})
+	db.Close()
+
+	// a torn record at the end of file, like a crash in the middle of write
+	info, _ := os.Stat(path)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3, 4, 1, 0, 0})
+	f.Close()
+
+	db = connect(t, path)
+
+	if n, _ := db.Len(); n != 8 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+	if v := selectValue(db, "9"); v != "value9" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+	if selectValue(db, "0") != "" {
+		t.Fatal("deleted value is recovered")
+	}
+
+	if size, _ := db.Size(); size != info.Size() {
+		t.Fatalf("torn record isn't truncated: %d != %d", size, info.Size())
+	}
+
+	// new records are written after the truncated tail
+	db.Insert("new", []byte("new"), date)
+	db.Close()
+
+	db = connect(t, path)
+	if v := selectValue(db, "new"); v != "new" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+}
+
+func TestCorruptedRecord(t *testing.T) {
+	date := time.Now().Unix() + 60
+
+	for name, corrupt := range map[string]func(data []byte, i int){
+		// i is the offset of value3
+		"value":  func(data []byte, i int) { data[i] ^= 0xff },
+		"length": func(data []byte, i int) { data[i-1-8-4-2] = 0x40 },
+	} {
+		path := filepath.Join(t.TempDir(), "kickcore.cache")
+
+		db := connect(t, path)
+		for i := 0; i < 10; i++ {
+			db.Insert(strconv.Itoa(i), []byte("value"+strconv.Itoa(i)), date)
+		}
+		db.Close()
+
+		data, _ := os.ReadFile(path)
+		corrupt(data, bytes.Index(data, []byte("value3")))
+		os.WriteFile(path, data, 0644)
+
+		var logs bytes.Buffer
+
+		db, err := file.ConnectWithConfig(&file.Config{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.(cache.LoggingDriver).SetLogger(logging.MustNewLogger(logging.LEVEL_WARNING, &logging.Config{FileObject: &logs}))
+		if err = db.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(logs.String(), "corrupted records") {
+			t.Fatalf("%s: corrupted record isn't logged: %q", name, logs.String())
+		}
+
+		if n, _ := db.Len(); n != 9 {
+			t.Fatalf("%s: unexpected length: %d", name, n)
+		}
+		if selectValue(db, "3") != "" {
+			t.Fatalf("%s: corrupted value is selected", name)
+		}
+		for _, key := range []string{"2", "4", "9"} {
+			if v := selectValue(db, key); v != "value"+key {
+				t.Fatalf("%s: unexpected value of %s: %q", name, key, v)
+			}
+		}
+
+		// corrupted record is removed from file
+		size, _ := db.Size()
+		db.Close()
+
+		if info, _ := os.Stat(path); info.Size() != size || size >= int64(len(data)) {
+			t.Fatalf("%s: file isn't compacted: %d", name, info.Size())
+		}
+
+		db = connect(t, path)
+		if n, _ := db.Len(); n != 9 {
+			t.Fatalf("%s: unexpected length after compaction: %d", name, n)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kickcore.cache")
+	now := time.Now().Unix()
+
+	db := connect(t, path)
+	for i := 0; i < 100; i++ {
+		db.Insert(strconv.Itoa(i), make([]byte, 100), now+60)
+	}
+	for i := 0; i < 90; i++ {
+		db.Delete(strconv.Itoa(i))
+	}
+	db.Insert("expired", make([]byte, 100), now-1)
+
+	before, _ := db.Size()
+	if err := db.(*file.FileCacheDriver).Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := db.Size()
+
+	if after >= before/5 {
+		t.Fatalf("file isn't compacted: %d -> %d", before, after)
+	}
+
+	db.Insert("new", []byte("new"), now+60)
+	db.Close()
+
+	db = connect(t, path)
+	if n, _ := db.Len(); n != 11 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+	if v := selectValue(db, "new"); v != "new" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+
+	// closed driver isn't reopened by compaction
+	db = connect(t, filepath.Join(t.TempDir(), "empty.cache"))
+	db.Close()
+	if err := db.(*file.FileCacheDriver).Compact(); err == nil {
+		t.Fatal("closed file is compacted")
+	}
+	if err := db.PingContext(context.Background()); err == nil {
+		t.Fatal("closed driver is reopened")
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kickcore.cache")
+
+	db := connect(t, path)
+	db.Insert("a", []byte("1"), time.Now().Unix()+60)
+
+	if _, err := file.ConnectWithConfig(&file.Config{Path: path}); err == nil {
+		t.Fatal("locked file is opened")
+	}
+
+	// the file which replaces the locked file by compaction is locked too
+	if err := db.(*file.FileCacheDriver).Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.ConnectWithConfig(&file.Config{Path: path}); err == nil {
+		t.Fatal("compacted file is opened")
+	}
+
+	db.Close()
+
+	db = connect(t, path)
+	if v := selectValue(db, "a"); v != "1" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kickcore.cache")
+
+	db, err := cache.Open("file://" + path + "?compact=0&sync=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.Open("file://" + path + "?unknown=1"); err == nil {
+		t.Fatal("unknown parameter is accepted")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// lockFile does nothing; files aren't locked on this platform.
+func lockFile(file *os.File) error { return nil }
@@ -0,0 +1,237 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+)
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt int64
+}
+
+func (e *entry) size() int64 { return int64(len(e.key) + len(e.value)) }
+
+// MemoryCacheDriver is an in-process cache driver with LRU eviction.
+//
+// The size of cache is limited by number of entries and total bytes of keys and values;
+// when one of them is reached, the least recently used entries are evicted.
+type MemoryCacheDriver struct {
+	locker sync.Mutex
+
+	items map[string]*list.Element
+	ll    *list.List
+	bytes int64
+
+	maxEntries int
+	maxBytes   int64
+}
+
+func (db *MemoryCacheDriver) Init() error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	if db.items == nil {
+		db.items = make(map[string]*list.Element)
+		db.ll = list.New()
+	}
+	return nil
+}
+
+func (db *MemoryCacheDriver) PingContext(_ context.Context) error { return nil }
+
+func (db *MemoryCacheDriver) removeElement(el *list.Element) {
+	e := db.ll.Remove(el).(*entry)
+	delete(db.items, e.key)
+	db.bytes -= e.size()
+}
+
+func (db *MemoryCacheDriver) evict() {
+	for db.ll.Len() > 0 {
+		if (db.maxEntries <= 0 || db.ll.Len() <= db.maxEntries) && (db.maxBytes <= 0 || db.bytes <= db.maxBytes) {
+			return
+		}
+		db.removeElement(db.ll.Back())
+	}
+}
+
+func (db *MemoryCacheDriver) Insert(key string, value []byte, expiresAt int64) (bool, error) {
+	e := &entry{key: key, value: value, expiresAt: expiresAt}
+
+	if db.maxBytes > 0 && e.size() > db.maxBytes {
+		return false, nil
+	}
+
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	if el, ok := db.items[key]; ok {
+		if el.Value.(*entry).expiresAt > time.Now().Unix() {
+			return false, nil
+		}
+		db.removeElement(el)
+	}
+
+	db.items[key] = db.ll.PushFront(e)
+	db.bytes += e.size()
+	db.evict()
+
+	return true, nil
+}
+
+// Select doesn't copy the value; the caller must not modify it.
+func (db *MemoryCacheDriver) Select(key string, value *[]byte, expiresAt *int64) error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	el, ok := db.items[key]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*entry)
+	if e.expiresAt <= time.Now().Unix() {
+		return nil
+	}
+
+	db.ll.MoveToFront(el)
+	*value = e.value
+	*expiresAt = e.expiresAt
+	return nil
+}
+
+func (db *MemoryCacheDriver) SelectExpired(now int64) ([]string, error) {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	var keys []string
+	for key, el := range db.items {
+		if el.Value.(*entry).expiresAt <= now {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (db *MemoryCacheDriver) Delete(key string) (bool, error) {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	el, ok := db.items[key]
+	if !ok {
+		return false, nil
+	}
+
+	db.removeElement(el)
+	return true, nil
+}
+
+func (db *MemoryCacheDriver) DeleteMany(keys []string) (int64, error) {
+	var result int64
+
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	for _, key := range keys {
+		if el, ok := db.items[key]; ok {
+			db.removeElement(el)
+			result++
+		}
+	}
+
+	return result, nil
+}
+
+func (db *MemoryCacheDriver) Range(prefix string, f func(key string, value []byte, expiresAt int64) bool) error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	for el := db.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if strings.HasPrefix(e.key, prefix) && !f(e.key, e.value, e.expiresAt) {
+			break
+		}
+	}
+	return nil
+}
+
+func (db *MemoryCacheDriver) RangeKeys(prefix string, f func(key string, expiresAt int64) bool) error {
+	return db.Range(prefix, func(key string, _ []byte, expiresAt int64) bool { return f(key, expiresAt) })
+}
+
+func (db *MemoryCacheDriver) Len() (int64, error) {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+	return int64(db.ll.Len()), nil
+}
+
+// Returns total size of keys and values in bytes
+func (db *MemoryCacheDriver) Size() (int64, error) {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+	return db.bytes, nil
+}
+
+func (db *MemoryCacheDriver) Close() error {
+	db.locker.Lock()
+	defer db.locker.Unlock()
+
+	db.items = make(map[string]*list.Element)
+	db.ll.Init()
+	db.bytes = 0
+	return nil
+}
+
+// Connect creates a memory cache driver.
+//
+// Parameters:
+//   - maxEntries: maximum number of entries ( zero or negative means unlimited ).
+//   - maxBytes: maximum total size of keys and values in bytes ( zero or negative means unlimited ).
+func Connect(maxEntries int, maxBytes int64) (cache.CacheDriver, error) {
+	db := new(MemoryCacheDriver)
+	db.maxEntries = maxEntries
+	db.maxBytes = maxBytes
+	return db, nil
+}
+
+func init() { cache.Register("memory", open) }
+
+// open creates the driver by DSN, e.g. "memory://?max=50000&size=67108864":
+//   - max: maximum number of entries ( default unlimited ).
+//   - size: maximum bytes of keys and values ( default unlimited ).
+func open(dsn *url.URL) (cache.CacheDriver, error) {
+	if err := cache.CheckParams(dsn, "max", "size"); err != nil {
+		return nil, err
+	}
+
+	var (
+		maxEntries int
+		maxBytes   int64
+		err        error
+	)
+
+	query := dsn.Query()
+
+	if value := query.Get("max"); value != "" {
+		if maxEntries, err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := query.Get("size"); value != "" {
+		if maxBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return Connect(maxEntries, maxBytes)
+}
@@ -0,0 +1,91 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache/memory"
+)
+
+func TestLRUEviction(t *testing.T) {
+	db, _ := memory.Connect(2, 0)
+	db.Init()
+
+	date := time.Now().Unix() + 60
+
+	db.Insert("a", []byte("1"), date)
+	db.Insert("b", []byte("2"), date)
+
+	// "a" is recently used now
+	var value []byte
+	var expiresAt int64
+	db.Select("a", &value, &expiresAt)
+
+	db.Insert("c", []byte("3"), date)
+
+	value = nil
+	if db.Select("b", &value, &expiresAt); value != nil {
+		t.Fatal("least recently used entry isn't evicted")
+	}
+
+	value = nil
+	if db.Select("a", &value, &expiresAt); string(value) != "1" {
+		t.Fatalf("unexpected value: %q", value)
+	}
+
+	if n, _ := db.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	db, _ := memory.Connect(0, 8)
+	db.Init()
+
+	date := time.Now().Unix() + 60
+
+	if ok, _ := db.Insert("key", []byte("too-large"), date); ok {
+		t.Fatal("inserted a value larger than max bytes")
+	}
+
+	db.Insert("a", []byte("1234"), date)
+	db.Insert("b", []byte("5678"), date)
+
+	if n, _ := db.Len(); n != 1 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestExpiredValues(t *testing.T) {
+	db, _ := memory.Connect(0, 0)
+	db.Init()
+
+	now := time.Now().Unix()
+
+	db.Insert("expired", []byte("1"), now-1)
+	db.Insert("alive", []byte("2"), now+60)
+
+	var value []byte
+	var expiresAt int64
+	if db.Select("expired", &value, &expiresAt); value != nil {
+		t.Fatal("selected an expired value")
+	}
+
+	keys, _ := db.SelectExpired(now)
+	if len(keys) != 1 || keys[0] != "expired" {
+		t.Fatalf("unexpected expired keys: %v", keys)
+	}
+
+	if n, _ := db.DeleteMany(keys); n != 1 {
+		t.Fatalf("unexpected deleted count: %d", n)
+	}
+
+	if ok, _ := db.Insert("alive", []byte("3"), now+60); ok {
+		t.Fatal("inserted a key which is currently in cache")
+	}
+
+	db.Insert("replaced", []byte("4"), now-1)
+	if ok, _ := db.Insert("replaced", []byte("5"), now+60); !ok {
+		t.Fatal("expired value isn't replaced")
+	}
+}
@@ -2,6 +2,7 @@ package noncache
 
 import (
 	"context"
+	"net/url"
 
 	"github.com/awolverp/kickcore/cache"
 )
@@ -14,19 +15,35 @@ func (c NonCache) PingContext(_ context.Context) error { return nil }
 
 func (c NonCache) Insert(_ string, _ []byte, _ int64) (bool, error) { return true, nil }
 
-func (c NonCache) Select(_ string, _ *[]byte) error { return nil }
+func (c NonCache) Select(_ string, _ *[]byte, _ *int64) error { return nil }
 
-func (c NonCache) SelectExpiredValues(_ int64) ([]string, error) { return []string{}, nil }
+func (c NonCache) SelectExpired(_ int64) ([]string, error) { return []string{}, nil }
 
 func (c NonCache) Delete(_ string) (bool, error) { return true, nil }
 
 func (c NonCache) DeleteMany(_ []string) (int64, error) { return 0, nil }
 
+func (c NonCache) Range(_ string, _ func(string, []byte, int64) bool) error { return nil }
+
+func (c NonCache) RangeKeys(_ string, _ func(string, int64) bool) error { return nil }
+
 func (c NonCache) Len() (int64, error) { return 0, nil }
 
+func (c NonCache) Size() (int64, error) { return 0, nil }
+
 func (c NonCache) Close() error { return nil }
 
 func Connect() (cache.CacheDriver, error) {
 	c := new(NonCache)
 	return c, nil
 }
+
+// Registered as "none://"
+func init() {
+	cache.Register("none", func(dsn *url.URL) (cache.CacheDriver, error) {
+		if err := cache.CheckParams(dsn); err != nil {
+			return nil, err
+		}
+		return Connect()
+	})
+}
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/awolverp/kickcore/api"
+)
+
+// TTLPolicy chooses TTL (in seconds) of the object which is fetched from upstream.
+// ttl is the configured TTL of the APICacheKey.
+type TTLPolicy func(obj interface{}, ttl int64) int64
+
+// Built-in policies parameters (in seconds)
+var (
+	// TTL of finished matches and finished competitions
+	PolicyFinishedTTL int64 = 3 * 24 * 60 * 60
+
+	// TTL of live matches
+	PolicyLiveTTL int64 = 15
+
+	// Upcoming matches are cached until this time before kickoff
+	PolicyKickoffMargin int64 = 10 * 60
+
+	// A match without status is considered live until this time after kickoff, unless it's finished
+	PolicyMatchDuration int64 = 3 * 60 * 60
+)
+
+func minTTL(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// State of a match which is chosen by its status type; see MatchStatusTypes
+type MatchState uint8
+
+const (
+	// State is unknown; it's guessed by kickoff time
+	MATCH_UNKNOWN MatchState = iota
+
+	// Match isn't started yet
+	MATCH_SCHEDULED
+
+	// Match is in progress, e.g. halftime, extra time, penalties; also delayed and
+	// suspended matches, which can start (or resume) at any time
+	MATCH_LIVE
+
+	// Match is finished
+	MATCH_FINISHED
+
+	// Match is postponed, canceled or abandoned; it may be rescheduled
+	MATCH_POSTPONED
+)
+
+// States of match status types (status_type field of upstream); status types are
+// matched case-insensitively, ignoring spaces, dashes and underscores.
+var MatchStatusTypes = map[string]MatchState{
+	"notstarted": MATCH_SCHEDULED,
+	"scheduled":  MATCH_SCHEDULED,
+	"upcoming":   MATCH_SCHEDULED,
+
+	"inprogress":     MATCH_LIVE,
+	"live":           MATCH_LIVE,
+	"halftime":       MATCH_LIVE,
+	"extratime":      MATCH_LIVE,
+	"penalties":      MATCH_LIVE,
+	"break":          MATCH_LIVE,
+	"delayed":        MATCH_LIVE,
+	"suspended":      MATCH_LIVE,
+	"interrupted":    MATCH_LIVE,
+	"awaitingresult": MATCH_LIVE,
+
+	"finished":       MATCH_FINISHED,
+	"ended":          MATCH_FINISHED,
+	"fulltime":       MATCH_FINISHED,
+	"afterextratime": MATCH_FINISHED,
+	"afterpenalties": MATCH_FINISHED,
+
+	"postponed": MATCH_POSTPONED,
+	"abandoned": MATCH_POSTPONED,
+	"canceled":  MATCH_POSTPONED,
+	"cancelled": MATCH_POSTPONED,
+}
+
+// matchState returns the state of statusType; MATCH_UNKNOWN if it's empty or unknown.
+func matchState(statusType string) MatchState {
+	if statusType == "" {
+		return MATCH_UNKNOWN
+	}
+
+	normalized := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '_' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, statusType)
+
+	return MatchStatusTypes[normalized]
+}
+
+func matchTTL(isFinished bool, statusType string, holdsAt, ttl, now int64) int64 {
+	state := matchState(statusType)
+	if isFinished {
+		state = MATCH_FINISHED
+	}
+
+	switch state {
+	case MATCH_FINISHED:
+		return PolicyFinishedTTL
+
+	case MATCH_LIVE:
+		return minTTL(PolicyLiveTTL, ttl)
+
+	case MATCH_POSTPONED:
+		return ttl
+
+	case MATCH_SCHEDULED:
+		if holdsAt == 0 {
+			return ttl
+		}
+		if now < holdsAt-PolicyKickoffMargin {
+			return minTTL(holdsAt-PolicyKickoffMargin-now, PolicyFinishedTTL)
+		}
+		// kickoff is near (or passed); the match starts at any time
+		return minTTL(PolicyLiveTTL, ttl)
+	}
+
+	// status is missing; guess by kickoff time
+	switch {
+	case holdsAt == 0:
+		return ttl
+
+	case now < holdsAt-PolicyKickoffMargin:
+		return minTTL(holdsAt-PolicyKickoffMargin-now, PolicyFinishedTTL)
+
+	case now < holdsAt+PolicyMatchDuration:
+		return minTTL(PolicyLiveTTL, ttl)
+	}
+
+	return ttl
+}
+
+// MatchPolicy chooses TTL by status of matches (see MatchStatusTypes):
+//   - finished matches are cached for PolicyFinishedTTL.
+//   - upcoming matches are cached until PolicyKickoffMargin before kickoff.
+//   - live, delayed and suspended matches are cached for PolicyLiveTTL.
+//   - postponed and canceled matches are cached for the configured TTL.
+//
+// If status of a match is missing or unknown, it's guessed by kickoff time: a match is
+// considered live until PolicyMatchDuration after kickoff.
+//
+// For list of matches, the minimum TTL of matches is chosen.
+//
+// Supports *api.MatchInfo, api.CompetitionMatches and []api.MatchBase.
+func MatchPolicy(obj interface{}, ttl int64) int64 {
+	now := time.Now().Unix()
+
+	matchesTTL := func(matches []api.MatchBase, result int64) int64 {
+		for _, m := range matches {
+			result = minTTL(result, matchTTL(m.IsFinished, m.StatusDetails.StatusType, int64(m.HoldsAt), ttl, now))
+		}
+		return result
+	}
+
+	switch v := obj.(type) {
+	case *api.MatchInfo:
+		if v != nil {
+			return matchTTL(v.IsFinished, v.Status.StatusType, int64(v.HoldsAt), ttl, now)
+		}
+
+	case []api.MatchBase:
+		if len(v) != 0 {
+			return matchesTTL(v, PolicyFinishedTTL)
+		}
+
+	case api.CompetitionMatches:
+		result := PolicyFinishedTTL
+		count := 0
+
+		for _, c := range v {
+			result = matchesTTL(c.Matches, result)
+			count += len(c.Matches)
+		}
+
+		if count != 0 {
+			return result
+		}
+	}
+
+	return ttl
+}
+
+// WeeksPolicy caches the weeks of finished competitions (current week is the last week)
+// for PolicyFinishedTTL.
+//
+// Supports *api.CompetitionWeeks.
+func WeeksPolicy(obj interface{}, ttl int64) int64 {
+	if v, ok := obj.(*api.CompetitionWeeks); ok && v != nil && len(v.Weeks) != 0 {
+		if v.CurrentWeek.WeekNumber >= v.Weeks[len(v.Weeks)-1].WeekNumber {
+			return PolicyFinishedTTL
+		}
+	}
+
+	return ttl
+}
+
+// StandingTablePolicy caches the standing table of finished competitions (every team played
+// a double round-robin) for PolicyFinishedTTL.
+//
+// Supports api.StandingTable.
+func StandingTablePolicy(obj interface{}, ttl int64) int64 {
+	v, ok := obj.(api.StandingTable)
+	if !ok || len(v) < 2 {
+		return ttl
+	}
+
+	rounds := 2 * (len(v) - 1)
+	for _, row := range v {
+		if row.PlayedMatches != rounds {
+			return ttl
+		}
+	}
+
+	return PolicyFinishedTTL
+}
@@ -0,0 +1,83 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+)
+
+func TestMatchPolicy(t *testing.T) {
+	now := int(time.Now().Unix())
+
+	tests := []struct {
+		name  string
+		match api.MatchInfo
+		want  int64
+	}{
+		{"finished", api.MatchInfo{IsFinished: true, HoldsAt: now - 7200}, cache.PolicyFinishedTTL},
+		{"live", api.MatchInfo{HoldsAt: now - 600}, cache.PolicyLiveTTL},
+		{"upcoming", api.MatchInfo{HoldsAt: now + 3600}, 3600 - cache.PolicyKickoffMargin},
+		{"unknown", api.MatchInfo{}, 60},
+	}
+
+	for _, test := range tests {
+		got := cache.MatchPolicy(&test.match, 60)
+
+		// one second tolerance for upcoming matches
+		if got != test.want && got != test.want-1 {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	matches := []api.MatchBase{
+		{IsFinished: true, HoldsAt: now - 7200},
+		{HoldsAt: now - 600},
+	}
+
+	if got := cache.MatchPolicy(matches, 60); got != cache.PolicyLiveTTL {
+		t.Errorf("list: got %d, want %d", got, cache.PolicyLiveTTL)
+	}
+}
+
+func TestMatchPolicyStatus(t *testing.T) {
+	now := int(time.Now().Unix())
+
+	tests := []struct {
+		statusType string
+		holdsAt    int
+		want       int64
+	}{
+		{"notstarted", now + 3600, 3600 - cache.PolicyKickoffMargin},
+		{"notstarted", now - 60, cache.PolicyLiveTTL},
+		{"inprogress", now - 600, cache.PolicyLiveTTL},
+		{"halftime", now - 3000, cache.PolicyLiveTTL},
+		{"extra_time", now - 4*3600, cache.PolicyLiveTTL},
+		{"penalties", now - 4*3600, cache.PolicyLiveTTL},
+		{"delayed", now - 3600, cache.PolicyLiveTTL},
+		{"Suspended", now - 4*3600, cache.PolicyLiveTTL},
+		{"finished", now - 600, cache.PolicyFinishedTTL},
+		{"postponed", now - 600, 60},
+		{"canceled", now + 3600, 60},
+		{"unknown-status", now - 600, cache.PolicyLiveTTL},
+	}
+
+	for _, test := range tests {
+		match := api.MatchInfo{HoldsAt: test.holdsAt}
+		match.Status.StatusType = test.statusType
+
+		got := cache.MatchPolicy(&match, 60)
+		if got != test.want && got != test.want-1 {
+			t.Errorf("MatchInfo %s: got %d, want %d", test.statusType, got, test.want)
+		}
+
+		base := api.MatchBase{HoldsAt: test.holdsAt}
+		base.StatusDetails.StatusType = test.statusType
+
+		got = cache.MatchPolicy([]api.MatchBase{base}, 60)
+		if got != test.want && got != test.want-1 {
+			t.Errorf("MatchBase %s: got %d, want %d", test.statusType, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DriverConstructor creates a cache driver by its DSN; e.g. for "memory://?max=50000",
+// dsn.Query() is {"max": ["50000"]}.
+type DriverConstructor func(dsn *url.URL) (CacheDriver, error)
+
+var (
+	driversLocker sync.RWMutex
+	drivers       = make(map[string]DriverConstructor)
+)
+
+// Register makes a cache driver available by the URL scheme (see Open).
+// It panics if constructor is nil or scheme is already registered.
+//
+// Drivers register themselves in their init function, so importing a driver package
+// is enough to use it:
+//
+//	import _ "github.com/awolverp/kickcore/cache/memory"
+func Register(scheme string, constructor DriverConstructor) {
+	driversLocker.Lock()
+	defer driversLocker.Unlock()
+
+	if constructor == nil {
+		panic("cache: Register constructor is nil")
+	}
+
+	scheme = strings.ToLower(scheme)
+	if _, ok := drivers[scheme]; ok {
+		panic("cache: Register called twice for scheme " + scheme)
+	}
+
+	drivers[scheme] = constructor
+}
+
+// Drivers returns the sorted schemes of registered drivers.
+func Drivers() []string {
+	driversLocker.RLock()
+	defer driversLocker.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open creates a cache driver by dsn which its scheme selects the driver,
+// e.g. "sqlite:///var/kickcore.db?wal=1" or "memory://?max=50000".
+//
+// The result can be passed to NewCache:
+//
+//	c, err := cache.NewCache(cache.Open("memory://?max=50000"))
+func Open(dsn string) (CacheDriver, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" {
+		return nil, errors.New("cache: DSN has no scheme: '" + dsn + "'")
+	}
+
+	driversLocker.RLock()
+	constructor, ok := drivers[strings.ToLower(u.Scheme)]
+	driversLocker.RUnlock()
+
+	if !ok {
+		return nil, errors.New("cache: unknown driver '" + u.Scheme + "' (registered: " + strings.Join(Drivers(), ", ") + ")")
+	}
+
+	return constructor(u)
+}
+
+// CheckParams returns an error if query of dsn has any parameter which isn't in names;
+// it helps drivers to reject misspelled parameters.
+func CheckParams(dsn *url.URL, names ...string) error {
+	for name := range dsn.Query() {
+		var ok bool
+		for _, value := range names {
+			if name == value {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return errors.New("cache: unknown parameter '" + name + "' for '" + dsn.Scheme + "' driver")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// call is an in-flight or completed group.do call
+type call struct {
+	done chan struct{}
+
+	// context of fn; canceled when every waiter has left
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// number of callers which wait for the call ( guarded by group.locker );
+	// detached calls (see group.doAsync) aren't canceled when it reaches zero.
+	waiters  int
+	detached bool
+
+	value []byte
+	err   error
+}
+
+// group coalesces concurrent calls with the same key into a single call.
+type group struct {
+	locker sync.Mutex
+	calls  map[string]*call
+}
+
+// begin registers a new call for key, or joins the in-flight call; returns false if the call exists.
+// If wait is true, the caller is counted as a waiter of the call.
+func (g *group) begin(key string, wait bool) (*call, bool) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{}), detached: !wait}
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		g.calls[key] = c
+	}
+
+	if wait {
+		c.waiters++
+	}
+
+	return c, !ok
+}
+
+// leave removes a waiter of c; the call is canceled and forgotten if it has no waiters.
+func (g *group) leave(key string, c *call) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	c.waiters--
+	if c.waiters == 0 && !c.detached {
+		c.cancel()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+	}
+}
+
+func (g *group) run(key string, c *call, fn func(context.Context) ([]byte, error)) {
+	defer func() {
+		g.locker.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.locker.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+
+	c.value, c.err = fn(c.ctx)
+}
+
+// do executes fn and returns its results, making sure that only one execution is
+// in-flight for a given key at a time. If a duplicate comes in, the duplicate caller
+// waits for the original to complete and receives the same results.
+//
+// fn isn't run by ctx: if ctx is done, the caller stops waiting and gets ctx.Err(), but fn
+// is canceled only when all of its callers have left.
+//
+// shared is true if the results were given to the caller by another call.
+func (g *group) do(
+	ctx context.Context, key string, fn func(context.Context) ([]byte, error),
+) (value []byte, shared bool, err error) {
+	c, ok := g.begin(key, true)
+	if ok {
+		go g.run(key, c, fn)
+	}
+
+	select {
+	case <-c.done:
+		return c.value, !ok, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return nil, !ok, ctx.Err()
+	}
+}
+
+// doAsync executes fn in a new goroutine, unless a call for key is already in-flight.
+// fn is never canceled. onError is called if fn returns error.
+func (g *group) doAsync(key string, fn func(context.Context) ([]byte, error), onError func(error)) {
+	c, ok := g.begin(key, false)
+	if !ok {
+		return
+	}
+
+	go func() {
+		g.run(key, c, fn)
+		if c.err != nil && onError != nil {
+			onError(c.err)
+		}
+	}()
+}
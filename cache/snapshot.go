@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// An entry of cache snapshot; snapshots are written as JSON lines.
+type SnapshotEntry struct {
+	// Name of APICacheKey (e.g. "MATCH_INFO"); empty if key doesn't belong to any APICacheKey
+	Namespace string `json:"namespace"`
+
+	// Schema version of APICacheKey (see APICacheKey.Version)
+	Version int `json:"version,omitempty"`
+
+	// Key without APICacheKey prefix
+	Key string `json:"key"`
+
+	// Stored value (including its header), encoded by base64
+	Value []byte `json:"value"`
+
+	// Expiration time (unix time)
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// namespaceOf returns the name of APICacheKey which key belongs to, and the key without its prefix.
+// Returns false if key belongs to another version of the APICacheKey.
+func namespaceOf(key string) (string, string, bool) {
+	for name, apikey := range mapVars {
+		if apikey.Key == "" || !strings.HasPrefix(key, apikey.Key) {
+			continue
+		}
+
+		prefix := apikey.prefix()
+		if !strings.HasPrefix(key, prefix) {
+			return name, key[len(apikey.Key):], false
+		}
+		return name, key[len(prefix):], true
+	}
+	return "", key, true
+}
+
+// Export writes all values of cache which aren't expired (and aren't stored by other versions of
+// their APICacheKey) into w as JSON lines (see SnapshotEntry).
+// Returns the number of written values.
+func (c *Cache) Export(w io.Writer) (int64, error) {
+	var n int64
+	var werr error
+
+	now := time.Now().Unix()
+	encoder := json.NewEncoder(w)
+
+	err := c.driver.Range("", func(key string, value []byte, expiresAt int64) bool {
+		if expiresAt <= now {
+			return true
+		}
+
+		namespace, key, current := namespaceOf(key)
+		if !current {
+			return true
+		}
+
+		var version int
+		if namespace != "" {
+			version = mapVars[namespace].Version
+		}
+
+		werr = encoder.Encode(SnapshotEntry{
+			Namespace: namespace, Version: version, Key: key, Value: value, ExpiresAt: expiresAt,
+		})
+		if werr != nil {
+			return false
+		}
+
+		n++
+		return true
+	})
+	if err != nil {
+		return n, err
+	}
+
+	return n, werr
+}
+
+// Import reads JSON lines (see SnapshotEntry) from r and inserts them into cache.
+// Expired values, values of unknown namespaces or other versions of namespaces, and values whose
+// key is currently in cache are skipped.
+//
+// Returns the number of inserted and skipped values.
+func (c *Cache) Import(r io.Reader) (int64, int64, error) {
+	var inserted, skipped int64
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var e SnapshotEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return inserted, skipped, err
+		}
+
+		key := e.Key
+		if e.Namespace != "" {
+			apikey, ok := mapVars[e.Namespace]
+			if !ok || e.Version != apikey.Version {
+				skipped++
+				continue
+			}
+			key = apikey.prefix() + key
+		}
+
+		if e.ExpiresAt <= time.Now().Unix() {
+			skipped++
+			continue
+		}
+
+		ok, err := c.driver.Insert(key, e.Value, e.ExpiresAt)
+		if err != nil {
+			return inserted, skipped, err
+		}
+
+		if ok {
+			inserted++
+		} else {
+			skipped++
+		}
+	}
+
+	return inserted, skipped, scanner.Err()
+}
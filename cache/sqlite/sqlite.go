@@ -4,15 +4,142 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/awolverp/kickcore/cache"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Policy of choosing rows which are evicted when database is full
+type EvictionPolicy uint8
+
+const (
+	// Evicts least recently accessed rows
+	EVICTION_LRU EvictionPolicy = iota
+
+	// Evicts rows which expire sooner
+	EVICTION_EXPIRES
+)
+
+// ParseEvictionPolicy returns the eviction policy by its name: "lru" or "expires".
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "", "lru":
+		return EVICTION_LRU, nil
+	case "expires":
+		return EVICTION_EXPIRES, nil
+	}
+	return EVICTION_LRU, errors.New("unknown eviction policy: '" + name + "'")
+}
+
+type Config struct {
+	// Data source name, e.g. path of database file
+	DSN string
+
+	// Connecting timeout ( default 1m )
+	Timeout time.Duration
+
+	// Maximum number of rows; zero means unlimited.
+	// It's a soft limit: it's checked after every 64 inserts (see SQLiteCacheDriver.Evict),
+	// so the database may have up to 63 more rows between checks.
+	MaxRows int64
+
+	// Maximum size of database in bytes; zero means unlimited.
+	// It's a soft limit like MaxRows.
+	MaxBytes int64
+
+	// Policy of choosing rows which are evicted when database is full
+	Eviction EvictionPolicy
+
+	// Journal mode: "delete", "truncate", "persist", "memory", "wal" or "off";
+	// empty means SQLite default ("delete")
+	JournalMode string
+
+	// Synchronous level: "off", "normal", "full" or "extra"; empty means SQLite default ("full")
+	Synchronous string
+
+	// Duration which a connection waits for the locked database before failing
+	BusyTimeout time.Duration
+
+	// Maximum number of open connections; zero means unlimited
+	MaxOpenConns int
+
+	// Maximum number of idle connections; zero means database/sql default (2),
+	// negative means no idle connections
+	MaxIdleConns int
+}
+
+func hasName(names []string, name string) bool {
+	for _, value := range names {
+		if value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dsn returns c.DSN with the connection parameters of go-sqlite3.
+func (c *Config) dsn() (string, error) {
+	params := url.Values{}
+
+	// Write transactions take the write lock at beginning, so they're never
+	// failed by upgrading read lock to write lock.
+	params.Set("_txlock", "immediate")
+
+	if c.JournalMode != "" {
+		mode := strings.ToLower(c.JournalMode)
+		if !hasName([]string{"delete", "truncate", "persist", "memory", "wal", "off"}, mode) {
+			return "", errors.New("unknown sqlite journal mode: '" + c.JournalMode + "'")
+		}
+		params.Set("_journal_mode", strings.ToUpper(mode))
+	}
+
+	if c.Synchronous != "" {
+		level := strings.ToLower(c.Synchronous)
+		if !hasName([]string{"off", "normal", "full", "extra"}, level) {
+			return "", errors.New("unknown sqlite synchronous level: '" + c.Synchronous + "'")
+		}
+		params.Set("_synchronous", strings.ToUpper(level))
+	}
+
+	if c.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(c.BusyTimeout.Milliseconds(), 10))
+	}
+
+	if strings.ContainsRune(c.DSN, '?') {
+		return c.DSN + "&" + params.Encode(), nil
+	}
+	return c.DSN + "?" + params.Encode(), nil
+}
+
+// Limits are checked after every evictionInterval inserts.
+const evictionInterval = 64
+
+// accessed_at of a row is updated at most once in accessGranularity seconds.
+const accessGranularity = 60
+
 type SQLiteCacheDriver struct {
 	conn *sql.DB
+
+	// Prepared statements; they're prepared by Init
+	insertStmt, selectStmt, touchStmt, deleteStmt, expiredStmt *sql.Stmt
+
+	// Serializes writes of this process; other processes are waited by busy timeout
+	writer sync.Mutex
+
+	maxRows  int64
+	maxBytes int64
+	eviction EvictionPolicy
+
+	inserts atomic.Int64
 }
 
 func (db *SQLiteCacheDriver) execTx(ctx context.Context, isolationLevel sql.IsolationLevel, callback func(*sql.Tx) error) error {
@@ -30,41 +157,280 @@ func (db *SQLiteCacheDriver) execTx(ctx context.Context, isolationLevel sql.Isol
 	return tx.Commit()
 }
 
-func (db *SQLiteCacheDriver) Init() error {
-	err := db.execTx(context.Background(), sql.LevelSerializable, func(tx *sql.Tx) error {
-		_, err := tx.Exec(
-			`CREATE TABLE IF NOT EXISTS cache(key TEXT PRIMARY KEY, value BLOB, date BIGINT);`,
-		)
+// migrations[i] migrates the database schema from version i to version i+1.
+// The schema version is kept in 'user_version' pragma.
+var migrations = []func(tx *sql.Tx) error{
+	// 1: explicit expiration time; older versions keep expiration time in 'date' column.
+	func(tx *sql.Tx) error {
+		var hasDate bool
+		err := tx.QueryRow(
+			`SELECT COUNT(*) > 0 FROM pragma_table_info('cache') WHERE name='date';`,
+		).Scan(&hasDate)
+		if err != nil {
+			return err
+		}
+
+		if hasDate {
+			_, err = tx.Exec(`ALTER TABLE cache RENAME COLUMN date TO expires_at;`)
+		} else {
+			_, err = tx.Exec(
+				`CREATE TABLE IF NOT EXISTS cache(key TEXT PRIMARY KEY, value BLOB, expires_at BIGINT NOT NULL);`,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS cache_expires_at ON cache(expires_at);`)
+		return err
+	},
+
+	// 2: access time of rows, for evicting least recently accessed rows.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE cache ADD COLUMN accessed_at BIGINT NOT NULL DEFAULT 0;`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS cache_accessed_at ON cache(accessed_at);`)
+		return err
+	},
+}
+
+func (db *SQLiteCacheDriver) migrate() error {
+	return db.execTx(context.Background(), sql.LevelSerializable, func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+			return err
+		}
+
+		if version > len(migrations) {
+			return fmt.Errorf("sqlite: unknown schema version %d (max %d)", version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("sqlite: migrating schema to version %d: %w", version+1, err)
+			}
+		}
+
+		_, err := tx.Exec(`PRAGMA user_version=` + strconv.Itoa(version) + `;`)
 		return err
 	})
+}
+
+// Enables incremental auto vacuum; databases which are created by older versions
+// are vacuumed once to enable it.
+func (db *SQLiteCacheDriver) enableAutoVacuum() error {
+	var mode int
+	if err := db.conn.QueryRow(`PRAGMA auto_vacuum;`).Scan(&mode); err != nil {
+		return err
+	}
+
+	// 2: incremental
+	if mode == 2 {
+		return nil
+	}
+
+	// auto_vacuum must be changed and vacuumed on the same connection
+	conn, err := db.conn.Conn(context.Background())
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	_, err = db.conn.Exec(`VACUUM`)
+	if _, err = conn.ExecContext(context.Background(), `PRAGMA auto_vacuum=INCREMENTAL;`); err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(context.Background(), `VACUUM;`)
 	return err
 }
 
-func (db *SQLiteCacheDriver) PingContext(ctx context.Context) error { return db.conn.PingContext(ctx) }
+// Returns the freed pages to file system.
+func (db *SQLiteCacheDriver) incrementalVacuum() error {
+	// incremental_vacuum frees a page per step, so rows must be read until the end
+	rows, err := db.conn.Query(`PRAGMA incremental_vacuum;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// prepare prepares the statements which are used frequently.
+func (db *SQLiteCacheDriver) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{
+			&db.insertStmt,
+			`INSERT INTO cache(key,value,expires_at,accessed_at) VALUES(?,?,?,?)
+			ON CONFLICT(key) DO UPDATE SET value=excluded.value, expires_at=excluded.expires_at, accessed_at=excluded.accessed_at
+			WHERE cache.expires_at <= ?;`,
+		},
+		{&db.selectStmt, `SELECT value, expires_at, accessed_at FROM cache WHERE key=? AND expires_at > ? LIMIT 1;`},
+		{&db.touchStmt, `UPDATE cache SET accessed_at=? WHERE key=?;`},
+		{&db.deleteStmt, `DELETE FROM cache WHERE key=?;`},
+		{&db.expiredStmt, `SELECT key FROM cache WHERE expires_at <= ?;`},
+	}
 
-func (db *SQLiteCacheDriver) Insert(key string, value []byte, date int64) (bool, error) {
-	var result bool = false
+	for _, value := range statements {
+		stmt, err := db.conn.Prepare(value.query)
+		if err != nil {
+			return err
+		}
+		*value.stmt = stmt
+	}
+
+	return nil
+}
+
+func (db *SQLiteCacheDriver) Init() error {
+	if err := db.enableAutoVacuum(); err != nil {
+		return err
+	}
+
+	if err := db.migrate(); err != nil {
+		return err
+	}
+
+	if err := db.prepare(); err != nil {
+		return err
+	}
+
+	return db.Evict()
+}
+
+// Evict deletes expired rows and evicts rows by eviction policy until database isn't full.
+// It's called by Init and after every evictionInterval inserts.
+func (db *SQLiteCacheDriver) Evict() error {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	return db.evict()
+}
+
+// usedBytes returns the size of database pages which are in use.
+func (db *SQLiteCacheDriver) usedBytes() (int64, error) {
+	var result int64
+
+	err := db.conn.QueryRow(
+		`SELECT (page_count - freelist_count) * page_size FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size();`,
+	).Scan(&result)
+
+	return result, err
+}
+
+// evict deletes expired rows and evicts rows by db.eviction until database isn't full.
+// db.writer must be locked.
+func (db *SQLiteCacheDriver) evict() error {
+	if db.maxRows <= 0 && db.maxBytes <= 0 {
+		return nil
+	}
+
+	order := `accessed_at`
+	if db.eviction == EVICTION_EXPIRES {
+		order = `expires_at`
+	}
+
+	var evicted int64
 
 	err := db.execTx(context.Background(), sql.LevelReadCommitted, func(tx *sql.Tx) error {
-		_, err := tx.Exec(`INSERT INTO cache(key,value,date) VALUES(?,?,?);`, key, value, date)
+		result, err := tx.Exec(`DELETE FROM cache WHERE expires_at <= ?;`, time.Now().Unix())
 		if err != nil {
-			return nil
+			return err
+		}
+		n, _ := result.RowsAffected()
+		evicted += n
+
+		if db.maxRows > 0 {
+			result, err = tx.Exec(
+				`DELETE FROM cache WHERE key IN (
+					SELECT key FROM cache ORDER BY `+order+` LIMIT max(0, (SELECT COUNT(*) FROM cache) - ?)
+				);`,
+				db.maxRows,
+			)
+			if err != nil {
+				return err
+			}
+			n, _ = result.RowsAffected()
+			evicted += n
 		}
 
-		result = true
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return result, err
+	// Evicts 10% of rows until database size is less than db.maxBytes
+	for db.maxBytes > 0 {
+		size, err := db.usedBytes()
+		if err != nil {
+			return err
+		}
+		if size <= db.maxBytes {
+			break
+		}
+
+		result, err := db.conn.Exec(
+			`DELETE FROM cache WHERE key IN (
+				SELECT key FROM cache ORDER BY ` + order + ` LIMIT max(1, (SELECT COUNT(*) FROM cache) / 10)
+			);`,
+		)
+		if err != nil {
+			return err
+		}
+
+		n, _ := result.RowsAffected()
+		if n == 0 {
+			break
+		}
+		evicted += n
+	}
+
+	if evicted > 0 {
+		return db.incrementalVacuum()
+	}
+	return nil
+}
+
+func (db *SQLiteCacheDriver) PingContext(ctx context.Context) error { return db.conn.PingContext(ctx) }
+
+func (db *SQLiteCacheDriver) Insert(key string, value []byte, expiresAt int64) (bool, error) {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+
+	now := time.Now().Unix()
+
+	// The upsert is a single statement, so it's atomic without transaction.
+	sqlresult, err := db.insertStmt.Exec(key, value, expiresAt, now, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, _ := sqlresult.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+
+	if db.inserts.Add(1)%evictionInterval == 0 {
+		err = db.evict()
+	}
+
+	return true, err
 }
 
-func (db *SQLiteCacheDriver) Select(key string, value *[]byte) error {
-	err := db.conn.QueryRow(`SELECT value FROM cache WHERE key=? LIMIT 1;`, key).Scan(value)
+func (db *SQLiteCacheDriver) Select(key string, value *[]byte, expiresAt *int64) error {
+	var accessedAt int64
+
+	now := time.Now().Unix()
+
+	err := db.selectStmt.QueryRow(key, now).Scan(value, expiresAt, &accessedAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -73,13 +439,17 @@ func (db *SQLiteCacheDriver) Select(key string, value *[]byte) error {
 		return err
 	}
 
+	if db.eviction == EVICTION_LRU && (db.maxRows > 0 || db.maxBytes > 0) && now-accessedAt >= accessGranularity {
+		db.writer.Lock()
+		_, err = db.touchStmt.Exec(now, key)
+		db.writer.Unlock()
+	}
+
 	return err
 }
 
-func (db *SQLiteCacheDriver) SelectExpiredValues(expireAfter int64) ([]string, error) {
-	rows, err := db.conn.Query(
-		`SELECT key FROM cache WHERE (?-date > ?);`, time.Now().Unix(), expireAfter-1,
-	)
+func (db *SQLiteCacheDriver) SelectExpired(now int64) ([]string, error) {
+	rows, err := db.expiredStmt.Query(now)
 	if err != nil {
 		return nil, err
 	}
@@ -101,63 +471,149 @@ func (db *SQLiteCacheDriver) SelectExpiredValues(expireAfter int64) ([]string, e
 }
 
 func (db *SQLiteCacheDriver) Delete(key string) (bool, error) {
-	var result bool = false
+	db.writer.Lock()
+	defer db.writer.Unlock()
+
+	sqlresult, err := db.deleteStmt.Exec(key)
+	if err != nil {
+		return false, err
+	}
+
+	n, _ := sqlresult.RowsAffected()
+	return n != 0, nil
+}
+
+// Maximum number of keys which are deleted by a statement; SQLite limits the number of
+// bound parameters of a statement (999 in versions before 3.32.0).
+const deleteChunkSize = 500
+
+// placeholders returns "(?,?,...)" with n parameters.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", n), ",") + ")"
+}
+
+func (db *SQLiteCacheDriver) DeleteMany(keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	var result int64
+
+	db.writer.Lock()
+	defer db.writer.Unlock()
 
 	err := db.execTx(context.Background(), sql.LevelReadCommitted, func(tx *sql.Tx) error {
-		sqlresult, err := tx.Exec(`DELETE FROM cache WHERE key=?;`, key)
-		if err != nil {
-			return err
+		var stmt *sql.Stmt
+
+		for start := 0; start < len(keys); start += deleteChunkSize {
+			chunk := keys[start:]
+			if len(chunk) > deleteChunkSize {
+				chunk = chunk[:deleteChunkSize]
+			}
+
+			args := make([]interface{}, len(chunk))
+			for i, key := range chunk {
+				args[i] = key
+			}
+
+			var sqlresult sql.Result
+			var err error
+
+			if len(chunk) == deleteChunkSize {
+				// full chunks share a statement
+				if stmt == nil {
+					stmt, err = tx.Prepare(`DELETE FROM cache WHERE key IN ` + placeholders(deleteChunkSize) + `;`)
+					if err != nil {
+						return err
+					}
+					defer stmt.Close()
+				}
+				sqlresult, err = stmt.Exec(args...)
+			} else {
+				sqlresult, err = tx.Exec(`DELETE FROM cache WHERE key IN `+placeholders(len(chunk))+`;`, args...)
+			}
+			if err != nil {
+				return err
+			}
+
+			n, _ := sqlresult.RowsAffected()
+			result += n
 		}
 
-		n, _ := sqlresult.RowsAffected()
-		result = (n != 0)
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	if result > 0 {
+		err = db.incrementalVacuum()
+	}
 
 	return result, err
 }
 
-func stringListToQuery(keys []string) string {
-	length := len(keys)
-
-	if length == 0 {
-		return "()"
-	} else if length == 1 {
-		return `('` + keys[0] + `')`
+func (db *SQLiteCacheDriver) Range(prefix string, f func(key string, value []byte, expiresAt int64) bool) error {
+	rows, err := db.conn.Query(
+		`SELECT key, value, expires_at FROM cache WHERE substr(key, 1, ?)=?;`, utf8.RuneCountInString(prefix), prefix,
+	)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	var s string = "("
+	for rows.Next() {
+		var (
+			key       string
+			value     []byte
+			expiresAt int64
+		)
+
+		if err = rows.Scan(&key, &value, &expiresAt); err != nil {
+			return err
+		}
 
-	for i, v := range keys {
-		s += `'` + v + `'`
-		if i < length-1 {
-			s += ","
+		if !f(key, value, expiresAt) {
+			break
 		}
 	}
 
-	return s + ")"
+	return rows.Err()
 }
 
-func (db *SQLiteCacheDriver) DeleteMany(keys []string) (int64, error) {
-	if len(keys) == 0 {
-		return 0, nil
-	}
+// RangeKeys selects only keys and expiration times, so large values aren't read.
+func (db *SQLiteCacheDriver) RangeKeys(prefix string, f func(key string, expiresAt int64) bool) error {
+	var rows *sql.Rows
+	var err error
 
-	var result int64
+	if prefix == "" {
+		rows, err = db.conn.Query(`SELECT key, expires_at FROM cache;`)
+	} else {
+		rows, err = db.conn.Query(
+			`SELECT key, expires_at FROM cache WHERE substr(key, 1, ?)=?;`, utf8.RuneCountInString(prefix), prefix,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	err := db.execTx(context.Background(), sql.LevelReadCommitted, func(tx *sql.Tx) error {
-		sqlresult, err := tx.Exec(
-			`DELETE FROM cache WHERE key IN ` + stringListToQuery(keys),
+	for rows.Next() {
+		var (
+			key       string
+			expiresAt int64
 		)
-		if err != nil {
+
+		if err = rows.Scan(&key, &expiresAt); err != nil {
 			return err
 		}
 
-		result, _ = sqlresult.RowsAffected()
-		return nil
-	})
+		if !f(key, expiresAt) {
+			break
+		}
+	}
 
-	return result, err
+	return rows.Err()
 }
 
 func (db *SQLiteCacheDriver) Len() (int64, error) {
@@ -171,14 +627,54 @@ func (db *SQLiteCacheDriver) Len() (int64, error) {
 	return result, nil
 }
 
-func (db *SQLiteCacheDriver) Close() error { return db.conn.Close() }
+// Returns the size of database file in bytes
+func (db *SQLiteCacheDriver) Size() (int64, error) {
+	var result int64
+
+	err := db.conn.QueryRow(
+		`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size();`,
+	).Scan(&result)
+	if err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+func (db *SQLiteCacheDriver) Close() error {
+	for _, stmt := range []*sql.Stmt{db.insertStmt, db.selectStmt, db.touchStmt, db.deleteStmt, db.expiredStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return db.conn.Close()
+}
 
 func Connect(dsn string, timeout time.Duration) (cache.CacheDriver, error) {
+	return ConnectWithConfig(&Config{DSN: dsn, Timeout: timeout})
+}
+
+func ConnectWithConfig(c *Config) (cache.CacheDriver, error) {
+	if c == nil {
+		return nil, errors.New("argument (*Config) is nil")
+	}
+
+	dsn, err := c.dsn()
+	if err != nil {
+		return nil, err
+	}
+
 	conn, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	conn.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns != 0 {
+		conn.SetMaxIdleConns(c.MaxIdleConns)
+	}
+
+	timeout := c.Timeout
 	if timeout == 0 {
 		timeout = time.Minute
 	}
@@ -193,5 +689,105 @@ func Connect(dsn string, timeout time.Duration) (cache.CacheDriver, error) {
 
 	db := new(SQLiteCacheDriver)
 	db.conn = conn
+	db.maxRows = c.MaxRows
+	db.maxBytes = c.MaxBytes
+	db.eviction = c.Eviction
 	return db, nil
 }
+
+func init() { cache.Register("sqlite", open) }
+
+// open creates the driver by DSN, e.g. "sqlite:///var/kickcore.db?wal=1" (absolute path)
+// or "sqlite://db.sqlite3" (relative path). Parameters are the fields of Config:
+//   - wal: enables ("1") or disables ("0") WAL journal mode.
+//   - journal, sync: journal mode and synchronous level.
+//   - timeout, busy_timeout: durations, e.g. "5s".
+//   - max_open, max_idle: connection pool limits.
+//   - rows, size, eviction: database limits and eviction policy.
+func open(dsn *url.URL) (cache.CacheDriver, error) {
+	err := cache.CheckParams(
+		dsn, "wal", "journal", "sync", "timeout", "busy_timeout", "max_open", "max_idle", "rows", "size", "eviction",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Config{DSN: dsn.Opaque}
+	if c.DSN == "" {
+		c.DSN = dsn.Host + dsn.Path
+	}
+	if c.DSN == "" {
+		c.DSN = "db.sqlite3"
+	}
+
+	query := dsn.Query()
+
+	if value := query.Get("wal"); value != "" {
+		wal, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("sqlite: invalid 'wal' parameter: '" + value + "'")
+		}
+
+		if wal {
+			c.JournalMode = "wal"
+		} else {
+			c.JournalMode = "delete"
+		}
+	}
+
+	if value := query.Get("journal"); value != "" {
+		c.JournalMode = value
+	}
+	c.Synchronous = query.Get("sync")
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"timeout", &c.Timeout},
+		{"busy_timeout", &c.BusyTimeout},
+	}
+	for _, param := range durations {
+		if value := query.Get(param.name); value != "" {
+			if *param.dst, err = time.ParseDuration(value); err != nil {
+				return nil, errors.New("sqlite: invalid '" + param.name + "' parameter: " + err.Error())
+			}
+		}
+	}
+
+	integers := []struct {
+		name string
+		dst  *int64
+	}{
+		{"rows", &c.MaxRows},
+		{"size", &c.MaxBytes},
+	}
+	for _, param := range integers {
+		if value := query.Get(param.name); value != "" {
+			if *param.dst, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, errors.New("sqlite: invalid '" + param.name + "' parameter: " + err.Error())
+			}
+		}
+	}
+
+	conns := []struct {
+		name string
+		dst  *int
+	}{
+		{"max_open", &c.MaxOpenConns},
+		{"max_idle", &c.MaxIdleConns},
+	}
+	for _, param := range conns {
+		if value := query.Get(param.name); value != "" {
+			if *param.dst, err = strconv.Atoi(value); err != nil {
+				return nil, errors.New("sqlite: invalid '" + param.name + "' parameter: " + err.Error())
+			}
+		}
+	}
+
+	if c.Eviction, err = ParseEvictionPolicy(query.Get("eviction")); err != nil {
+		return nil, err
+	}
+
+	return ConnectWithConfig(&c)
+}
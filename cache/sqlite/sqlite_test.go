@@ -0,0 +1,369 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/sqlite"
+)
+
+var hostileKeys = []string{
+	"",
+	"'",
+	"a'b",
+	`"`,
+	`\`,
+	"'); DROP TABLE cache; --",
+	"' OR '1'='1",
+	"%",
+	"_",
+	"?",
+	"-- comment",
+	"/* comment */",
+	"\x00zero",
+	"line\nbreak",
+	"فوتبال",
+	"⚽ emoji",
+	strings.Repeat("x", 4096),
+}
+
+func connect(t *testing.T, c *sqlite.Config) cache.CacheDriver {
+	t.Helper()
+
+	if c == nil {
+		c = &sqlite.Config{}
+	}
+	if c.DSN == "" {
+		c.DSN = filepath.Join(t.TempDir(), "db.sqlite3")
+	}
+
+	db, err := sqlite.ConnectWithConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestHostileKeys(t *testing.T) {
+	db := connect(t, nil)
+
+	date := time.Now().Unix() + 60
+
+	db.Insert("safe", []byte("safe"), date)
+
+	for _, key := range hostileKeys {
+		if ok, err := db.Insert(key, []byte(key), date); !ok || err != nil {
+			t.Fatalf("inserting %q: %v, %v", key, ok, err)
+		}
+	}
+
+	for _, key := range hostileKeys {
+		var value []byte
+		var expiresAt int64
+
+		if err := db.Select(key, &value, &expiresAt); err != nil || string(value) != key || expiresAt != date {
+			t.Fatalf("selecting %q: %q, %d, %v", key, value, expiresAt, err)
+		}
+	}
+
+	n, err := db.DeleteMany(hostileKeys)
+	if err != nil || n != int64(len(hostileKeys)) {
+		t.Fatalf("unexpected result of DeleteMany: %d, %v", n, err)
+	}
+
+	if n, err := db.Len(); err != nil || n != 1 {
+		t.Fatalf("unexpected length: %d, %v", n, err)
+	}
+
+	var value []byte
+	var expiresAt int64
+	if db.Select("safe", &value, &expiresAt); string(value) != "safe" {
+		t.Fatalf("unexpected value: %q", value)
+	}
+}
+
+func TestHostileDelete(t *testing.T) {
+	db := connect(t, nil)
+
+	date := time.Now().Unix() + 60
+
+	db.Insert("a", []byte("1"), date)
+	db.Insert("b", []byte("2"), date)
+
+	for _, key := range hostileKeys {
+		if ok, err := db.Delete(key); ok || err != nil {
+			t.Fatalf("deleting %q: %v, %v", key, ok, err)
+		}
+	}
+
+	if n, err := db.DeleteMany(hostileKeys); n != 0 || err != nil {
+		t.Fatalf("unexpected result of DeleteMany: %d, %v", n, err)
+	}
+
+	if n, _ := db.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestHostileRange(t *testing.T) {
+	db := connect(t, nil)
+
+	date := time.Now().Unix() + 60
+
+	keys := []string{"%a", "_a", "%%", "ab", "فو", "فوتبال"}
+	for _, key := range keys {
+		db.Insert(key, []byte(key), date)
+	}
+
+	tests := map[string][]string{
+		"%":  {"%a", "%%"},
+		"_":  {"_a"},
+		"a":  {"ab"},
+		"فو": {"فو", "فوتبال"},
+		"'":  nil,
+	}
+
+	for prefix, expected := range tests {
+		var result []string
+
+		err := db.Range(prefix, func(key string, _ []byte, _ int64) bool {
+			result = append(result, key)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != len(expected) {
+			t.Fatalf("prefix %q: unexpected keys: %q", prefix, result)
+		}
+
+		result = nil
+		err = db.RangeKeys(prefix, func(key string, expiresAt int64) bool {
+			if expiresAt != date {
+				t.Errorf("key %q: unexpected expiration time: %d", key, expiresAt)
+			}
+			result = append(result, key)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != len(expected) {
+			t.Fatalf("prefix %q: unexpected keys of RangeKeys: %q", prefix, result)
+		}
+	}
+
+	var n int
+	db.RangeKeys("", func(string, int64) bool { n++; return true })
+	if n != len(keys) {
+		t.Fatalf("unexpected number of keys: %d", n)
+	}
+}
+
+func TestDeleteManyChunks(t *testing.T) {
+	db := connect(t, nil)
+
+	date := time.Now().Unix() + 60
+
+	keys := make([]string, 2500)
+	for i := range keys {
+		keys[i] = "key'" + strconv.Itoa(i)
+		db.Insert(keys[i], []byte("v"), date)
+	}
+
+	n, err := db.DeleteMany(keys[:2001])
+	if err != nil || n != 2001 {
+		t.Fatalf("unexpected result of DeleteMany: %d, %v", n, err)
+	}
+
+	if n, _ := db.Len(); n != 499 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestInsertExpired(t *testing.T) {
+	db := connect(t, nil)
+
+	now := time.Now().Unix()
+
+	db.Insert("live", []byte("1"), now+60)
+	if ok, _ := db.Insert("live", []byte("2"), now+60); ok {
+		t.Fatal("live value is replaced")
+	}
+
+	db.Insert("expired", []byte("1"), now-1)
+
+	var value []byte
+	var expiresAt int64
+	if db.Select("expired", &value, &expiresAt); value != nil {
+		t.Fatal("expired value is selected")
+	}
+
+	if keys, _ := db.SelectExpired(now); len(keys) != 1 || keys[0] != "expired" {
+		t.Fatalf("unexpected expired keys: %q", keys)
+	}
+
+	if ok, _ := db.Insert("expired", []byte("2"), now+60); !ok {
+		t.Fatal("expired value isn't replaced")
+	}
+}
+
+func TestMigration(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	// schema of older versions
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Exec(`CREATE TABLE cache(key TEXT PRIMARY KEY, value BLOB, date BIGINT);`)
+	conn.Exec(`INSERT INTO cache VALUES('a''b', 'old', ?);`, time.Now().Unix()+60)
+	conn.Close()
+
+	db := connect(t, &sqlite.Config{DSN: dsn})
+
+	var value []byte
+	var expiresAt int64
+	if db.Select("a'b", &value, &expiresAt); string(value) != "old" {
+		t.Fatalf("unexpected value: %q", value)
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	db := connect(t, &sqlite.Config{MaxRows: 10, Eviction: sqlite.EVICTION_EXPIRES})
+
+	now := time.Now().Unix()
+
+	for i := 0; i < 1000; i++ {
+		db.Insert(strconv.Itoa(i), []byte("v"), now+int64(i)+60)
+	}
+
+	// the limit is checked while inserting
+	if n, _ := db.Len(); n >= 1000 {
+		t.Fatalf("database isn't evicted: %d", n)
+	}
+
+	if err := db.(*sqlite.SQLiteCacheDriver).Evict(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := db.Len(); n != 10 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+
+	// values which expire sooner are evicted
+	var value []byte
+	var expiresAt int64
+	for i := 990; i < 1000; i++ {
+		if db.Select(strconv.Itoa(i), &value, &expiresAt); value == nil {
+			t.Fatalf("value %d is evicted", i)
+		}
+	}
+}
+
+func TestEvictionLRU(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "db.sqlite3")
+	db := connect(t, &sqlite.Config{DSN: dsn, MaxRows: 10, Eviction: sqlite.EVICTION_LRU})
+
+	date := time.Now().Unix() + 60
+
+	for i := 0; i < 10; i++ {
+		db.Insert(strconv.Itoa(i), []byte("v"), date)
+	}
+
+	// rows are accessed an hour ago
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Exec(`UPDATE cache SET accessed_at = accessed_at - 3600;`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	var value []byte
+	var expiresAt int64
+
+	db.Select("0", &value, &expiresAt)
+	db.Select("1", &value, &expiresAt)
+
+	for i := 10; i < 15; i++ {
+		db.Insert(strconv.Itoa(i), []byte("v"), date)
+	}
+
+	if err := db.(*sqlite.SQLiteCacheDriver).Evict(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := db.Len(); n != 10 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+
+	// recently read and inserted rows survive
+	for _, key := range []string{"0", "1", "10", "11", "12", "13", "14"} {
+		if value = nil; db.Select(key, &value, &expiresAt) != nil || value == nil {
+			t.Errorf("value %s is evicted", key)
+		}
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	for _, journal := range []string{"", "wal"} {
+		db := connect(t, &sqlite.Config{JournalMode: journal, BusyTimeout: time.Second * 5})
+		date := time.Now().Unix() + 60
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20*50)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				var value []byte
+				var expiresAt int64
+
+				for j := 0; j < 50; j++ {
+					key := strconv.Itoa(i) + "-" + strconv.Itoa(j)
+					if ok, err := db.Insert(key, []byte(key), date); err != nil || !ok {
+						errs <- fmt.Errorf("insert %s: %v, %v", key, ok, err)
+						continue
+					}
+					if err := db.Select(key, &value, &expiresAt); err != nil || string(value) != key {
+						errs <- fmt.Errorf("select %s: %q, %v", key, value, err)
+					}
+					if j%10 == 0 {
+						if _, err := db.Delete(key); err != nil {
+							errs <- fmt.Errorf("delete %s: %v", key, err)
+						}
+					}
+				}
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("journal %q: %v", journal, err)
+		}
+
+		if n, _ := db.Len(); n != 20*45 {
+			t.Fatalf("journal %q: unexpected length: %d", journal, n)
+		}
+	}
+}
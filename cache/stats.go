@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics of an APICacheKey
+type Stats struct {
+	// Number of fresh values returned from cache
+	Hits uint64 `json:"hits"`
+
+	// Number of stale values returned from cache
+	Stale uint64 `json:"stale"`
+
+	// Number of lookups which are not returned from cache
+	Misses uint64 `json:"misses"`
+
+	// Number of values inserted into cache
+	Inserts uint64 `json:"inserts"`
+
+	// Number of values which are failed to insert into cache
+	InsertFailures uint64 `json:"insert_failures"`
+
+	// Number of expired values deleted from cache
+	Expired uint64 `json:"expired"`
+
+	// Number of upstream calls and their average time
+	Fetches      uint64        `json:"fetches"`
+	AvgFetchTime time.Duration `json:"avg_fetch_time_ns"`
+}
+
+type counters struct {
+	hits, stale, misses     atomic.Uint64
+	inserts, insertFailures atomic.Uint64
+	expired                 atomic.Uint64
+	fetches                 atomic.Uint64
+	fetchTime               atomic.Int64
+}
+
+func (c *counters) stats() Stats {
+	s := Stats{
+		Hits:           c.hits.Load(),
+		Stale:          c.stale.Load(),
+		Misses:         c.misses.Load(),
+		Inserts:        c.inserts.Load(),
+		InsertFailures: c.insertFailures.Load(),
+		Expired:        c.expired.Load(),
+		Fetches:        c.fetches.Load(),
+	}
+
+	if s.Fetches != 0 {
+		s.AvgFetchTime = time.Duration(c.fetchTime.Load() / int64(s.Fetches))
+	}
+	return s
+}
+
+// statistics keeps counters of each APICacheKey
+type statistics struct {
+	locker sync.RWMutex
+	keys   map[string]*counters
+}
+
+func (s *statistics) get(apikey string) *counters {
+	s.locker.RLock()
+	c, ok := s.keys[apikey]
+	s.locker.RUnlock()
+
+	if ok {
+		return c
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if s.keys == nil {
+		s.keys = make(map[string]*counters)
+	}
+
+	if c, ok = s.keys[apikey]; !ok {
+		c = new(counters)
+		s.keys[apikey] = c
+	}
+	return c
+}
+
+// countExpired counts the expired keys by their APICacheKey.
+func (s *statistics) countExpired(keys []string) {
+	for _, key := range keys {
+		for _, apikey := range mapVars {
+			if apikey.Key != "" && strings.HasPrefix(key, apikey.Key) {
+				s.get(apikey.Key).expired.Add(1)
+				break
+			}
+		}
+	}
+}
+
+// Returns the statistics of each APICacheKey by its name.
+func (s *statistics) all() map[string]Stats {
+	result := make(map[string]Stats, len(mapVars))
+	names := make(map[string]string, len(mapVars))
+
+	for name, apikey := range mapVars {
+		names[apikey.Key] = name
+		result[name] = Stats{}
+	}
+
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	for key, c := range s.keys {
+		name, ok := names[key]
+		if !ok {
+			name = key
+		}
+		result[name] = c.stats()
+	}
+
+	return result
+}
@@ -0,0 +1,120 @@
+package cache_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+)
+
+func TestStats(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	f := func() (interface{}, error) { return "value", nil }
+
+	fresh := cache.NewAPICacheKey("fresh", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	c.CacheFuncJSON(fresh, "1", f) // miss
+	c.CacheFuncJSON(fresh, "1", f) // hit
+	c.CacheFuncJSON(fresh, "1", f) // hit
+	c.CacheFuncJSON(fresh, "2", f) // miss
+
+	// the value is stale immediately, and refreshed in background
+	stale := cache.NewAPICacheKey("stale", cache.TTLConfig{StaleWhileRevalidate: 60}, nil)
+
+	c.CacheFuncJSON(stale, "1", f) // miss
+	if _, state, _ := c.CacheFuncJSONState(stale, "1", f); state != cache.STATE_STALE {
+		t.Fatalf("unexpected state: %s", state)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Stats()["stale"].Fetches != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stale value isn't refreshed")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	failed := cache.NewAPICacheKey("failed", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	c.CacheFuncJSON(failed, "1", func() (interface{}, error) { return nil, errors.New("upstream is down") })
+
+	stats := c.Stats()
+
+	// namespaces which are never used are reported with zero statistics
+	if s, ok := stats["MATCH_INFO"]; !ok || s != (cache.Stats{}) {
+		t.Errorf("unexpected stats of MATCH_INFO: %+v", s)
+	}
+
+	expected := map[string]cache.Stats{
+		"fresh":  {Hits: 2, Misses: 2, Inserts: 2, Fetches: 2},
+		"stale":  {Stale: 1, Misses: 1, Inserts: 2, Fetches: 2},
+		"failed": {Misses: 1, Fetches: 1},
+	}
+
+	for name, e := range expected {
+		s := stats[name]
+		s.AvgFetchTime = 0
+
+		if s != e {
+			t.Errorf("unexpected stats of %s: %+v != %+v", name, s, e)
+		}
+	}
+
+	if n := c.Coalesced(); n != 0 {
+		t.Fatalf("unexpected coalesced count: %d", n)
+	}
+}
+
+func TestStatsCoalesced(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	apikey := cache.NewAPICacheKey("coalesced", cache.TTLConfig{ExtraTTL: 60}, nil)
+
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.CacheFuncJSON(apikey, "1", func() (interface{}, error) {
+				<-release
+				return "value", nil
+			})
+		}()
+	}
+
+	// all of the callers miss before the value is fetched
+	deadline := time.Now().Add(time.Second)
+	for c.Stats()["coalesced"].Misses != 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("callers don't miss")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wg.Wait()
+
+	s := c.Stats()["coalesced"]
+	if s.Misses != 10 || s.Fetches != 1 || s.Inserts != 1 || s.AvgFetchTime <= 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	if n := c.Coalesced(); n != 9 {
+		t.Fatalf("unexpected coalesced count: %d", n)
+	}
+}
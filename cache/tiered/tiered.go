@@ -0,0 +1,200 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/logging"
+)
+
+// TieredCacheDriver puts a front cache driver (usually memory) in front of
+// a back cache driver (usually SQLite).
+//
+// Reads hit the front first and fall back to the back, writes and deletes go to both.
+type TieredCacheDriver struct {
+	front cache.CacheDriver
+	back  cache.CacheDriver
+}
+
+// SetLogger sets the logger of front and back drivers which log (see cache.LoggingDriver).
+func (db *TieredCacheDriver) SetLogger(logger *logging.FileLogger) {
+	for _, d := range []cache.CacheDriver{db.front, db.back} {
+		if d, ok := d.(cache.LoggingDriver); ok {
+			d.SetLogger(logger)
+		}
+	}
+}
+
+func (db *TieredCacheDriver) Init() error {
+	if err := db.front.Init(); err != nil {
+		return err
+	}
+	return db.back.Init()
+}
+
+func (db *TieredCacheDriver) PingContext(ctx context.Context) error {
+	if err := db.front.PingContext(ctx); err != nil {
+		return err
+	}
+	return db.back.PingContext(ctx)
+}
+
+func (db *TieredCacheDriver) Insert(key string, value []byte, expiresAt int64) (bool, error) {
+	result, err := db.back.Insert(key, value, expiresAt)
+	if err != nil {
+		return result, err
+	}
+
+	// the front may have an older value; if the key is alive in the back (result is false),
+	// the value of back is promoted on the next select.
+	db.front.Delete(key)
+	if result {
+		db.front.Insert(key, value, expiresAt)
+	}
+	return result, nil
+}
+
+// Select promotes the values which are found in the back to the front.
+func (db *TieredCacheDriver) Select(key string, value *[]byte, expiresAt *int64) error {
+	err := db.front.Select(key, value, expiresAt)
+	if err != nil || *value != nil {
+		return err
+	}
+
+	err = db.back.Select(key, value, expiresAt)
+	if err != nil || *value == nil {
+		return err
+	}
+
+	db.front.Insert(key, *value, *expiresAt)
+	return nil
+}
+
+func (db *TieredCacheDriver) SelectExpired(now int64) ([]string, error) {
+	keys, err := db.back.SelectExpired(now)
+	if err != nil {
+		return nil, err
+	}
+
+	frontKeys, err := db.front.SelectExpired(now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frontKeys) == 0 {
+		return keys, nil
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		seen[key] = struct{}{}
+	}
+
+	for _, key := range frontKeys {
+		if _, ok := seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (db *TieredCacheDriver) Delete(key string) (bool, error) {
+	frontResult, err := db.front.Delete(key)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.back.Delete(key)
+	return result || frontResult, err
+}
+
+func (db *TieredCacheDriver) DeleteMany(keys []string) (int64, error) {
+	frontResult, err := db.front.DeleteMany(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.back.DeleteMany(keys)
+	if frontResult > result {
+		result = frontResult
+	}
+	return result, err
+}
+
+// Range ranges over the back cache
+func (db *TieredCacheDriver) Range(prefix string, f func(key string, value []byte, expiresAt int64) bool) error {
+	return db.back.Range(prefix, f)
+}
+
+// RangeKeys ranges over the keys of back cache
+func (db *TieredCacheDriver) RangeKeys(prefix string, f func(key string, expiresAt int64) bool) error {
+	return db.back.RangeKeys(prefix, f)
+}
+
+// Returns the length of back cache
+func (db *TieredCacheDriver) Len() (int64, error) { return db.back.Len() }
+
+// Returns the size of back cache
+func (db *TieredCacheDriver) Size() (int64, error) { return db.back.Size() }
+
+func (db *TieredCacheDriver) Close() error {
+	err := db.front.Close()
+	if backErr := db.back.Close(); backErr != nil {
+		return backErr
+	}
+	return err
+}
+
+// Connect creates a two-tier cache driver.
+func Connect(front, back cache.CacheDriver) (cache.CacheDriver, error) {
+	if front == nil || back == nil {
+		return nil, errors.New("tiered: front and back drivers are required")
+	}
+
+	db := new(TieredCacheDriver)
+	db.front = front
+	db.back = back
+	return db, nil
+}
+
+func init() { cache.Register("tiered", open) }
+
+// DSN of the front driver of open if it isn't specified; 10000 entries and 64MB.
+const defaultFrontDSN = "memory://?max=10000&size=67108864"
+
+// open creates the driver by DSN; front and back drivers are specified by their
+// (URL encoded) DSN, e.g. "tiered://?front=memory%3A%2F%2F%3Fmax%3D1000&back=sqlite%3A%2F%2Fdb.sqlite3":
+//   - front: DSN of front driver ( default defaultFrontDSN ).
+//     An unbounded front (e.g. "memory://") keeps every value of back in memory.
+//   - back: DSN of back driver ( default "sqlite://db.sqlite3" ).
+func open(dsn *url.URL) (cache.CacheDriver, error) {
+	if err := cache.CheckParams(dsn, "front", "back"); err != nil {
+		return nil, err
+	}
+
+	query := dsn.Query()
+
+	frontDSN, backDSN := query.Get("front"), query.Get("back")
+	if frontDSN == "" {
+		frontDSN = defaultFrontDSN
+	}
+	if backDSN == "" {
+		backDSN = "sqlite://db.sqlite3"
+	}
+
+	front, err := cache.Open(frontDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	back, err := cache.Open(backDSN)
+	if err != nil {
+		front.Close()
+		return nil, err
+	}
+
+	return Connect(front, back)
+}
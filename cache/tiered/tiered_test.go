@@ -0,0 +1,153 @@
+package tiered_test
+
+import (
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+	"github.com/awolverp/kickcore/cache/tiered"
+)
+
+func connect(t *testing.T) (db, front, back cache.CacheDriver) {
+	front, _ = memory.Connect(0, 0)
+	back, _ = memory.Connect(0, 0)
+
+	db, err := tiered.Connect(front, back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return db, front, back
+}
+
+func selectValue(db cache.CacheDriver, key string) string {
+	var value []byte
+	var expiresAt int64
+	db.Select(key, &value, &expiresAt)
+	return string(value)
+}
+
+func TestReadThrough(t *testing.T) {
+	db, front, back := connect(t)
+	date := time.Now().Unix() + 60
+
+	back.Insert("key", []byte("1"), date)
+
+	if value := selectValue(front, "key"); value != "" {
+		t.Fatalf("unexpected front value: %q", value)
+	}
+
+	if value := selectValue(db, "key"); value != "1" {
+		t.Fatalf("unexpected value: %q", value)
+	}
+
+	// promoted to the front
+	if value := selectValue(front, "key"); value != "1" {
+		t.Fatalf("value isn't promoted: %q", value)
+	}
+}
+
+func TestInsertConflict(t *testing.T) {
+	db, front, back := connect(t)
+	date := time.Now().Unix() + 60
+
+	if ok, _ := db.Insert("key", []byte("1"), date); !ok {
+		t.Fatal("value isn't inserted")
+	}
+
+	// the front evicted the key while the back still has it
+	front.Delete("key")
+
+	if ok, _ := db.Insert("key", []byte("2"), date); ok {
+		t.Fatal("inserted a key which is currently in cache")
+	}
+
+	if value := selectValue(front, "key"); value != "" {
+		t.Fatalf("front has another value: %q", value)
+	}
+
+	for _, d := range []cache.CacheDriver{db, front, back} {
+		if value := selectValue(d, "key"); value != "1" {
+			t.Fatalf("tiers have different values: %q", value)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db, front, back := connect(t)
+	date := time.Now().Unix() + 60
+
+	for _, key := range []string{"a", "b", "c"} {
+		db.Insert(key, []byte(key), date)
+	}
+
+	if ok, _ := db.Delete("a"); !ok {
+		t.Fatal("value isn't deleted")
+	}
+
+	if n, _ := db.DeleteMany([]string{"b", "c"}); n != 2 {
+		t.Fatalf("unexpected deleted count: %d", n)
+	}
+
+	for _, d := range []cache.CacheDriver{front, back} {
+		if n, _ := d.Len(); n != 0 {
+			t.Fatalf("tier isn't empty: %d", n)
+		}
+	}
+}
+
+func TestSelectExpired(t *testing.T) {
+	db, front, back := connect(t)
+	now := time.Now().Unix()
+
+	front.Insert("front", []byte("1"), now-1)
+	back.Insert("back", []byte("2"), now-1)
+	db.Insert("both", []byte("3"), now-1)
+	db.Insert("alive", []byte("4"), now+60)
+
+	keys, err := db.SelectExpired(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(keys)
+	if len(keys) != 3 || keys[0] != "back" || keys[1] != "both" || keys[2] != "front" {
+		t.Fatalf("unexpected expired keys: %v", keys)
+	}
+
+	if n, _ := db.DeleteMany(keys); n != 2 {
+		t.Fatalf("unexpected deleted count: %d", n)
+	}
+
+	for _, d := range []cache.CacheDriver{front, back} {
+		if n, _ := d.Len(); n != 1 {
+			t.Fatalf("expired values aren't swept: %d", n)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	db, err := cache.Open("tiered://?back=" + url.QueryEscape("memory://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Insert("key", []byte("1"), time.Now().Unix()+60)
+	if value := selectValue(db, "key"); value != "1" {
+		t.Fatalf("unexpected value: %q", value)
+	}
+
+	if _, err = cache.Open("tiered://?front=" + url.QueryEscape("memory://?max=x")); err == nil {
+		t.Fatal("invalid front DSN is accepted")
+	}
+}
@@ -3,13 +3,10 @@ module github.com/awolverp/kickcore
 go 1.19
 
 require (
+	github.com/andybalholm/brotli v1.0.4
+	github.com/klauspost/compress v1.15.9
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/valyala/fasthttp v1.43.0
 )
 
-require (
-	github.com/andybalholm/brotli v1.0.4 // indirect
-	github.com/klauspost/compress v1.15.9 // indirect
-	github.com/valyala/bytebufferpool v1.0.0 // indirect
-
-)
+require github.com/valyala/bytebufferpool v1.0.0 // indirect
@@ -4,12 +4,17 @@ import (
 	"context"
 	"errors"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/awolverp/kickcore/api"
 	"github.com/awolverp/kickcore/cache"
+	_ "github.com/awolverp/kickcore/cache/file"
+	"github.com/awolverp/kickcore/cache/memory"
 	"github.com/awolverp/kickcore/cache/noncache"
 	"github.com/awolverp/kickcore/cache/sqlite"
+	"github.com/awolverp/kickcore/cache/tiered"
 	"github.com/awolverp/kickcore/logging"
 	"github.com/awolverp/kickcore/server"
 
@@ -39,9 +44,16 @@ type Core struct {
 	// Cache system
 	cache_struct *cache.Cache
 	expirator    *cache.ExpirationMachine
+	ttl_watcher  *cache.ExtraTTLWatcher
 
 	server_app fasthttp.Server
 	server_mux server.ServeMux
+
+	prefetcher *server.Prefetcher
+
+	// Context of upstream requests; canceled by core.Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type ConfigCore struct {
@@ -51,22 +63,81 @@ type ConfigCore struct {
 	APIClientReadTimeout  time.Duration
 	APIClientWriteTimeout time.Duration
 
-	// CacheSystem    string
+	// Upstream base URL and path prefix ( default is the original football API ); see api.SessionConfig
+	APIClientBaseURL    string
+	APIClientPathPrefix string
+
+	// Retry policy of upstream requests; see api.RetryPolicy
+	APIClientMaxAttempts int
+	APIClientBackoffBase time.Duration
+	APIClientBackoffCap  time.Duration
+	APIClientJitter      float64
+
+	// Comma-separated status codes which are retried, e.g. "502,503,504"
+	APIClientRetryCodes string
+
+	// Circuit breakers of upstream endpoint families; see api.BreakerConfig
+	APIClientBreakerThreshold int
+	APIClientBreakerTimeout   time.Duration
+
+	// Rate limiter of upstream requests; see api.LimiterConfig
+	APIClientRate        float64
+	APIClientMaxInFlight int
+	APIClientMaxWait     time.Duration
+
+	// Comma-separated requests per second of endpoint families, e.g. "search=2,match=5"
+	APIClientFamilyRate string
+
+	// Cache system: "sqlite", "memory", "tiered" ( default "sqlite" ), or DSN of a registered
+	// cache driver, e.g. "sqlite:///var/kickcore.db?wal=1" (see cache.Open)
+	CacheSystem                    string
 	DisableCaching                 bool
 	CacheSQLiteTimeout             time.Duration
 	CacheExpirationMachineInterval time.Duration
 	CacheExtraTTLFilename          string
+	CacheExtraTTLWatchInterval     time.Duration
 	CacheSQLiteDSN                 string
+	CacheSQLiteMaxRows             int64
+	CacheSQLiteMaxBytes            int64
+	CacheSQLiteEviction            string
+	CacheSQLiteJournalMode         string
+	CacheSQLiteSynchronous         string
+	CacheSQLiteBusyTimeout         time.Duration
+	CacheSQLiteMaxOpenConns        int
+	CacheSQLiteMaxIdleConns        int
+	CacheMemoryMaxEntries          int
+	CacheMemoryMaxBytes            int64
+
+	// Compression codec of cached values: "none", "deflate", "gzip", "brotli" or "zstd"
+	CacheCompression string
+
+	// Comma-separated codecs which compressed variants of cached values are stored by,
+	// and served to clients which accept them: "gzip", "brotli" or "zstd"
+	CacheEncodings string
 
 	ServerReadTimeout       time.Duration
 	ServerWriteTimeout      time.Duration
+	ServerRequestTimeout    time.Duration
 	ReduceServerMemoryUsage bool
 	ServerGetOnly           bool
 
 	ServerLogSpeed bool
+
+	// Token of admin URLs; if empty, admin URLs are disabled
+	AdminToken string
+
+	// Prefetches hot values at startup and after every interval; zero disables prefetching
+	PrefetchInterval time.Duration
+
+	// Comma-separated names of prefetched APICacheKeys (see server.PrefetchTargets); empty means all
+	PrefetchTargets string
+
+	// Maximum requests of prefetcher per second; zero means unlimited
+	PrefetchRate float64
 }
 
-func (core *Core) Init(c *ConfigCore) error {
+// InitCache initializes the logging and cache systems only; it's called by core.Init.
+func (core *Core) InitCache(c *ConfigCore) error {
 	if c == nil {
 		return errors.New("argument (*ConfigCore) is nil")
 	}
@@ -78,16 +149,37 @@ func (core *Core) Init(c *ConfigCore) error {
 		return err
 	}
 
-	core.api_client = api.NewSession(core.logger, c.APIClientReadTimeout, c.APIClientWriteTimeout)
-
 	if c.DisableCaching {
 		core.cache_struct, _ = cache.NewCache(noncache.Connect())
-	} else {
-		if c.CacheSQLiteDSN == "" {
-			c.CacheSQLiteDSN = "db.sqlite3"
+	} else if strings.Contains(c.CacheSystem, ":") {
+		// DSN of a registered driver, e.g. "memory://?max=50000"
+		var driver cache.CacheDriver
+
+		driver, err = cache.Open(c.CacheSystem)
+		if d, ok := driver.(cache.LoggingDriver); ok {
+			d.SetLogger(core.logger)
 		}
+		core.cache_struct, err = cache.NewCache(driver, err)
+	} else {
+		switch c.CacheSystem {
+		case "", "sqlite":
+			core.cache_struct, err = cache.NewCache(connectSQLite(c))
+
+		case "memory":
+			core.cache_struct, err = cache.NewCache(memory.Connect(c.CacheMemoryMaxEntries, c.CacheMemoryMaxBytes))
 
-		core.cache_struct, err = cache.NewCache(sqlite.Connect(c.CacheSQLiteDSN, c.CacheSQLiteTimeout))
+		case "tiered":
+			var front, back cache.CacheDriver
+
+			front, _ = memory.Connect(c.CacheMemoryMaxEntries, c.CacheMemoryMaxBytes)
+			back, err = connectSQLite(c)
+			if err == nil {
+				core.cache_struct, err = cache.NewCache(tiered.Connect(front, back))
+			}
+
+		default:
+			err = errors.New("unknown cache system: '" + c.CacheSystem + "'")
+		}
 	}
 	if err != nil {
 		return err
@@ -95,6 +187,89 @@ func (core *Core) Init(c *ConfigCore) error {
 
 	core.cache_struct.Logger = core.logger
 
+	core.cache_struct.Codec, err = cache.ParseCodec(c.CacheCompression)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(c.CacheEncodings, ",") {
+		codec, err := cache.ParseCodec(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+
+		switch codec {
+		case cache.CODEC_NONE:
+		case cache.CODEC_DEFLATE:
+			return errors.New("deflate can't be used as cache encoding")
+		default:
+			core.cache_struct.Encodings = append(core.cache_struct.Encodings, codec)
+		}
+	}
+
+	return nil
+}
+
+func (core *Core) Init(c *ConfigCore) error {
+	err := core.InitCache(c)
+	if err != nil {
+		return err
+	}
+
+	retryCodes := []int{}
+	for _, code := range strings.Split(c.APIClientRetryCodes, ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(code)
+		if err != nil || n < 100 || n > 599 {
+			return errors.New("invalid retry status code: '" + code + "'")
+		}
+		retryCodes = append(retryCodes, n)
+	}
+
+	familyRate := make(map[string]float64)
+	for _, item := range strings.Split(c.APIClientFamilyRate, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(item, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return errors.New("invalid rate limit of endpoint family: '" + item + "' ( e.g. 'match=5' )")
+		}
+		familyRate[strings.TrimSpace(name)] = rate
+	}
+
+	core.api_client, err = api.NewSessionWithConfig(core.logger, &api.SessionConfig{
+		ReadTimeout:  c.APIClientReadTimeout,
+		WriteTimeout: c.APIClientWriteTimeout,
+		BaseURL:      c.APIClientBaseURL,
+		PathPrefix:   c.APIClientPathPrefix,
+		Retry: api.RetryPolicy{
+			MaxAttempts:      c.APIClientMaxAttempts,
+			BackoffBase:      c.APIClientBackoffBase,
+			BackoffCap:       c.APIClientBackoffCap,
+			Jitter:           c.APIClientJitter,
+			RetryStatusCodes: retryCodes,
+		},
+		Breaker: api.BreakerConfig{
+			Threshold:   c.APIClientBreakerThreshold,
+			OpenTimeout: c.APIClientBreakerTimeout,
+		},
+		Limiter: api.LimiterConfig{
+			Rate:        c.APIClientRate,
+			FamilyRate:  familyRate,
+			MaxInFlight: c.APIClientMaxInFlight,
+			MaxWait:     c.APIClientMaxWait,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
 	if c.CacheExtraTTLFilename != "" {
 		err = cache.ReadExtraTTL(c.CacheExtraTTLFilename)
 		if err != nil {
@@ -114,6 +289,28 @@ func (core *Core) Init(c *ConfigCore) error {
 				return err
 			}
 		}
+
+		core.ttl_watcher = &cache.ExtraTTLWatcher{
+			Filename: c.CacheExtraTTLFilename,
+			Logger:   core.logger,
+		}
+
+		if c.CacheExtraTTLWatchInterval > 0 {
+			err = core.ttl_watcher.Start(c.CacheExtraTTLWatchInterval)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !c.DisableCaching {
+		// values of older object schemas are never selected
+		n, err := core.cache_struct.DeleteOldVersions()
+		if err != nil {
+			core.logger.Log(LOGGING_WARNING, "Deleting cached values of older versions: %s", err.Error())
+		} else if n > 0 {
+			core.logger.Log(LOGGING_INFO, "Deleted %d cached values of older versions", n)
+		}
 	}
 
 	var disable_cache_expiration bool = (c.CacheExpirationMachineInterval <= 0)
@@ -139,28 +336,104 @@ func (core *Core) Init(c *ConfigCore) error {
 		DisableKeepalive:  true,
 	}
 
+	core.ctx, core.cancel = context.WithCancel(context.Background())
+
 	core.server_mux = server.ServeMux{
-		APIClient: core.api_client,
-		Cache:     core.cache_struct,
-		Logger:    core.logger,
-		LogSpeed:  c.ServerLogSpeed,
+		Context:        core.ctx,
+		RequestTimeout: c.ServerRequestTimeout,
+		APIClient:      core.api_client,
+		Cache:          core.cache_struct,
+		Logger:         core.logger,
+		LogSpeed:       c.ServerLogSpeed,
+		AdminToken:     c.AdminToken,
 	}
 	core.server_mux.Init()
 
+	if c.PrefetchInterval > 0 && !c.DisableCaching {
+		core.prefetcher = &server.Prefetcher{
+			APIClient: core.api_client,
+			Cache:     core.cache_struct,
+			Logger:    core.logger,
+			Rate:      c.PrefetchRate,
+		}
+
+		for _, name := range strings.Split(c.PrefetchTargets, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				core.prefetcher.Targets = append(core.prefetcher.Targets, name)
+			}
+		}
+
+		err = core.prefetcher.Start(c.PrefetchInterval)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (core *Core) Urls() [][2]interface{} { return server.URLs }
+func connectSQLite(c *ConfigCore) (cache.CacheDriver, error) {
+	if c.CacheSQLiteDSN == "" {
+		c.CacheSQLiteDSN = "db.sqlite3"
+	}
+
+	eviction, err := sqlite.ParseEvictionPolicy(c.CacheSQLiteEviction)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlite.ConnectWithConfig(&sqlite.Config{
+		DSN:      c.CacheSQLiteDSN,
+		Timeout:  c.CacheSQLiteTimeout,
+		MaxRows:  c.CacheSQLiteMaxRows,
+		MaxBytes: c.CacheSQLiteMaxBytes,
+		Eviction: eviction,
+
+		JournalMode:  c.CacheSQLiteJournalMode,
+		Synchronous:  c.CacheSQLiteSynchronous,
+		BusyTimeout:  c.CacheSQLiteBusyTimeout,
+		MaxOpenConns: c.CacheSQLiteMaxOpenConns,
+		MaxIdleConns: c.CacheSQLiteMaxIdleConns,
+	})
+}
+
+// Closes the cache system.
+func (core *Core) CloseCache() error { return core.cache_struct.Close() }
+
+func (core *Core) Urls() [][2]interface{} {
+	return append(append([][2]interface{}{}, server.URLs...), server.AdminURLs...)
+}
 
 func (core *Core) OpenConnections() int32 { return core.server_app.GetOpenConnectionsCount() }
 
+// Reloads extra TTL file; if the file is invalid, the last good configuration is kept.
+func (core *Core) ReloadExtraTTL() error {
+	if core.ttl_watcher == nil {
+		return errors.New("extra ttl file isn't specified")
+	}
+	return core.ttl_watcher.Reload()
+}
+
+// Shutdown stops the server; in-flight requests have 5 seconds to complete, then their
+// upstream requests are canceled.
 func (core *Core) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	if core.expirator != nil {
 		core.expirator.Stop()
 	}
-	return core.server_app.ShutdownWithContext(ctx)
+	if core.ttl_watcher != nil && core.ttl_watcher.IsStarted() {
+		core.ttl_watcher.Stop()
+	}
+	if core.prefetcher != nil && core.prefetcher.IsStarted() {
+		core.prefetcher.Stop()
+	}
+
+	err := core.server_app.ShutdownWithContext(ctx)
+	if core.cancel != nil {
+		core.cancel()
+	}
+	return err
 }
 
 func (core *Core) Serve(addr string) error {
@@ -178,7 +451,7 @@ var defaultExtraTTL = []byte(`{
 	"COMPETITION_STANDING_TABLE": "5h",
 	"COMPETITION_WEEKS":          "1h",
 	"COMPETITIONS_LIST":          "24h",
-	"MATCH_INFO":                 "1m",
+	"MATCH_INFO":                 {"ttl": "1m", "negative_ttl": "30s"},
 	"MATCHES_BY_DATE":            "2m",
 	"MATCHES_BY_WEEKNUMBER":      "2m",
 	"TRANSFERS":                  "12h",
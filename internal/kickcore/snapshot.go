@@ -0,0 +1,87 @@
+package kickcore
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ExportCache writes all values of cache into filename as JSON lines; the file is compressed
+// by gzip or zstd if filename ends with ".gz" or ".zst".
+func (core *Core) ExportCache(filename string) (int64, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var w io.WriteCloser = nopWriteCloser{file}
+
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		w = gzip.NewWriter(file)
+	case strings.HasSuffix(filename, ".zst"):
+		w, err = zstd.NewWriter(file)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := core.cache_struct.Export(w)
+	if err != nil {
+		w.Close()
+		return n, err
+	}
+
+	if err = w.Close(); err != nil {
+		return n, err
+	}
+
+	core.logger.Log(LOGGING_INFO, "Exported %d values into '%s'", n, filename)
+	return n, file.Close()
+}
+
+// ImportCache reads values from filename which is written by core.ExportCache, and inserts
+// them into cache. Expired values are skipped.
+func (core *Core) ImportCache(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gr.Close()
+		r = gr
+
+	case strings.HasSuffix(filename, ".zst"):
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	inserted, skipped, err := core.cache_struct.Import(r)
+	if err != nil {
+		return inserted, err
+	}
+
+	core.logger.Log(LOGGING_INFO, "Imported %d values from '%s' (%d skipped)", inserted, filename, skipped)
+	return inserted, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
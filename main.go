@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/awolverp/kickcore/internal/kickcore"
@@ -26,51 +28,98 @@ var core kickcore.Core
 
 func main() {
 	sigchannel := make(chan os.Signal, 1)
+	hupchannel := make(chan os.Signal, 1)
+	readychannel := make(chan struct{})
 	donechannel := make(chan struct{})
 
-	go kickcore_server(donechannel)
+	go kickcore_server(readychannel, donechannel)
 
 	signal.Notify(sigchannel, os.Interrupt)
+	signal.Notify(hupchannel, syscall.SIGHUP)
+
+	// SIGHUP is handled after initializing core; a nil channel blocks forever
+	var hupsignals <-chan os.Signal
+
+	for {
+		select {
+		case <-readychannel:
+			hupsignals = hupchannel
+			readychannel = nil
+
+		case <-donechannel:
+			signal.Stop(sigchannel)
+			signal.Stop(hupchannel)
+			close(donechannel)
+			os.Exit(0)
+
+		case <-hupsignals:
+			if err := core.ReloadExtraTTL(); err != nil {
+				fmt.Println("ERROR", err)
+			}
 
-	select {
-	case <-donechannel:
-		signal.Stop(sigchannel)
-		close(donechannel)
-		os.Exit(0)
-
-	case <-sigchannel:
-		fmt.Printf("Please wait (for 5 seconds), don't try again (open connections %d) ...\n", core.OpenConnections())
-		core.Shutdown()
+		case <-sigchannel:
+			fmt.Printf("Please wait (for 5 seconds), don't try again (open connections %d) ...\n", core.OpenConnections())
+			core.Shutdown()
 
-		signal.Stop(sigchannel)
-		close(sigchannel)
+			signal.Stop(sigchannel)
+			signal.Stop(hupchannel)
+			close(sigchannel)
 
-		close(donechannel)
+			close(donechannel)
 
-		os.Exit(0)
+			os.Exit(0)
+		}
 	}
 }
 
-func kickcore_server(done chan<- struct{}) {
-	flag.Usage = func() { fmt.Printf(helpUsage, kickcore.Version(), os.Args[0]) }
+func kickcore_server(ready chan<- struct{}, done chan<- struct{}) {
+	flag.Usage = func() { fmt.Printf(helpUsage, kickcore.Version(), os.Args[0], os.Args[0]) }
 
 	// server
 	flag.StringVar(&ListenAddr, "l", "127.0.0.1:9090", "")
 	flag.DurationVar(&coreConfig.ServerReadTimeout, "server-timeout:read", time.Second*30, "")
 	flag.DurationVar(&coreConfig.ServerWriteTimeout, "server-timeout:write", time.Second*30, "")
+	flag.DurationVar(&coreConfig.ServerRequestTimeout, "server-timeout:request", time.Second*30, "")
 	flag.BoolVar(&coreConfig.ReduceServerMemoryUsage, "reduce-memory-usage", false, "")
 	flag.BoolVar(&coreConfig.ServerGetOnly, "get-only", false, "")
 
 	// cache
 	flag.BoolVar(&coreConfig.DisableCaching, "disable-cache", false, "")
+	flag.StringVar(&coreConfig.CacheSystem, "cache", "sqlite", "")
 	flag.DurationVar(&coreConfig.CacheExpirationMachineInterval, "expire:interval", time.Minute, "")
 	flag.StringVar(&coreConfig.CacheExtraTTLFilename, "expire:ttl", "extra_ttl.json", "")
+	flag.DurationVar(&coreConfig.CacheExtraTTLWatchInterval, "expire:watch", time.Second*5, "")
 	flag.StringVar(&coreConfig.CacheSQLiteDSN, "sqlite:dsn", "db.sqlite3", "")
 	flag.DurationVar(&coreConfig.CacheSQLiteTimeout, "sqlite:timeout", time.Minute, "")
-
-	// api client timeouts
+	flag.Int64Var(&coreConfig.CacheSQLiteMaxRows, "sqlite:rows", 0, "")
+	flag.Int64Var(&coreConfig.CacheSQLiteMaxBytes, "sqlite:size", 0, "")
+	flag.StringVar(&coreConfig.CacheSQLiteEviction, "sqlite:eviction", "lru", "")
+	flag.StringVar(&coreConfig.CacheSQLiteJournalMode, "sqlite:journal", "", "")
+	flag.StringVar(&coreConfig.CacheSQLiteSynchronous, "sqlite:sync", "", "")
+	flag.DurationVar(&coreConfig.CacheSQLiteBusyTimeout, "sqlite:busy-timeout", time.Second*5, "")
+	flag.IntVar(&coreConfig.CacheSQLiteMaxOpenConns, "sqlite:max-open", 0, "")
+	flag.IntVar(&coreConfig.CacheSQLiteMaxIdleConns, "sqlite:max-idle", 2, "")
+	flag.IntVar(&coreConfig.CacheMemoryMaxEntries, "memory:entries", 10000, "")
+	flag.Int64Var(&coreConfig.CacheMemoryMaxBytes, "memory:size", 64<<20, "")
+	flag.StringVar(&coreConfig.CacheCompression, "cache:compression", "none", "")
+	flag.StringVar(&coreConfig.CacheEncodings, "cache:encodings", "", "")
+
+	// api client
 	flag.DurationVar(&coreConfig.APIClientReadTimeout, "client-timeout:read", time.Second*20, "")
 	flag.DurationVar(&coreConfig.APIClientWriteTimeout, "client-timeout:write", time.Second*20, "")
+	flag.StringVar(&coreConfig.APIClientBaseURL, "client:url", "", "")
+	flag.StringVar(&coreConfig.APIClientPathPrefix, "client:prefix", "", "")
+	flag.IntVar(&coreConfig.APIClientMaxAttempts, "client:attempts", 1, "")
+	flag.DurationVar(&coreConfig.APIClientBackoffBase, "client:backoff", time.Millisecond*100, "")
+	flag.DurationVar(&coreConfig.APIClientBackoffCap, "client:backoff-cap", time.Second*2, "")
+	flag.Float64Var(&coreConfig.APIClientJitter, "client:jitter", 0.5, "")
+	flag.StringVar(&coreConfig.APIClientRetryCodes, "client:retry-codes", "502,503,504", "")
+	flag.IntVar(&coreConfig.APIClientBreakerThreshold, "client:breaker", 0, "")
+	flag.DurationVar(&coreConfig.APIClientBreakerTimeout, "client:breaker-timeout", time.Second*30, "")
+	flag.Float64Var(&coreConfig.APIClientRate, "client:rate", 0, "")
+	flag.StringVar(&coreConfig.APIClientFamilyRate, "client:family-rate", "", "")
+	flag.IntVar(&coreConfig.APIClientMaxInFlight, "client:max-inflight", 0, "")
+	flag.DurationVar(&coreConfig.APIClientMaxWait, "client:max-wait", time.Second*5, "")
 
 	// logging options
 	flag.IntVar(&coreConfig.LoggingLevel, "v", kickcore.LOGGING_WARNING, "")
@@ -78,6 +127,14 @@ func kickcore_server(done chan<- struct{}) {
 	flag.BoolVar(&logConfig.Append, "log:append", false, "")
 	flag.BoolVar(&coreConfig.ServerLogSpeed, "log:speed", false, "")
 
+	// prefetcher
+	flag.DurationVar(&coreConfig.PrefetchInterval, "prefetch:interval", time.Minute*10, "")
+	flag.StringVar(&coreConfig.PrefetchTargets, "prefetch:targets", "", "")
+	flag.Float64Var(&coreConfig.PrefetchRate, "prefetch:rate", 2, "")
+
+	// admin
+	flag.StringVar(&coreConfig.AdminToken, "admin:token", "", "")
+
 	// other
 	flag.BoolVar(&showUrls, "urls", false, "")
 	flag.BoolVar(&showVersion, "version", false, "")
@@ -112,6 +169,14 @@ func kickcore_server(done chan<- struct{}) {
 		return
 	}
 
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Println("ERROR", err)
+		}
+		done <- struct{}{}
+		return
+	}
+
 	fmt.Printf(
 		"KickIt Core Server %s (C) / by aWolverP - [%s] on %s/%s\n\n",
 		kickcore.Version(), runtime.Version(), runtime.GOOS, runtime.GOARCH,
@@ -124,6 +189,7 @@ func kickcore_server(done chan<- struct{}) {
 		done <- struct{}{}
 		return
 	}
+	close(ready)
 
 	if err := core.Serve(ListenAddr); err != nil {
 		fmt.Println("ERROR", err)
@@ -131,11 +197,45 @@ func kickcore_server(done chan<- struct{}) {
 	done <- struct{}{}
 }
 
+// runCommand runs the commands:
+//
+//	cache export <file>
+//	cache import <file>
+func runCommand(args []string) error {
+	if len(args) != 3 || args[0] != "cache" || (args[1] != "export" && args[1] != "import") {
+		return errors.New("unknown command: '" + strings.Join(args, " ") + "' (see -help)")
+	}
+
+	coreConfig.LoggingConfig = &logConfig
+
+	if err := core.InitCache(&coreConfig); err != nil {
+		return err
+	}
+	defer core.CloseCache()
+
+	if args[1] == "export" {
+		n, err := core.ExportCache(args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d values exported into '%s'\n", n, args[2])
+		return nil
+	}
+
+	n, err := core.ImportCache(args[2])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d values imported from '%s'\n", n, args[2])
+	return nil
+}
+
 var helpUsage = `NAME
        kickcore %s - KickCore Server (C)
 
 USAGE
        %s [OPTIONS]
+       %s [OPTIONS] cache (export|import) <file>
 
 DESCRIPTION
        kickcore (C) is a Football API server written in golang language.
@@ -159,6 +259,12 @@ OPTIONS
             is the maximum duration before timing out writes of the
             response. It is reset after the request handler has
             returned.
+
+      -server-timeout:request=duration     (default 30s)
+            is the maximum duration of upstream requests of a request.
+            Upstream requests are also canceled when the client
+            disconnects (but not when it only closes its write side),
+            unless other requests wait for them. Zero means no limit.
         
       -reduce-memory-usage
             reduces memory usage at the cost of higher CPU usage.
@@ -175,6 +281,22 @@ OPTIONS
       -disable-cache
             Disable cache. It slows down this server and maybe banned
             from original football API.
+
+      -cache=system     (default "sqlite")
+            Cache system. "sqlite" keeps objects in SQLite database,
+            "memory" keeps objects in memory and evicts least recently
+            used objects when it's full (see -memory:*), "tiered" keeps
+            hot objects in memory in front of SQLite database. The
+            "file" driver (only by DSN) keeps objects in an append-only
+            file and works without cgo.
+            It can also be DSN of a cache driver, which its options
+            are set by query parameters instead of -sqlite:* and
+            -memory:* options, e.g.:
+              sqlite:///var/kickcore.db?wal=1&rows=100000
+              memory://?max=50000&size=67108864
+              tiered://?front=memory%3A%2F%2F%3Fmax%3D10000&back=sqlite%3A%2F%2Fdb.sqlite3
+              file:///var/kickcore.cache?compact=10m&ratio=0.5
+              none://
         
       -expire:interval=duration     (default 1m)
             The Cache expiration machine checks the cache for expired
@@ -182,8 +304,15 @@ OPTIONS
         
       -expire:ttl=filename     (default "extra_ttl.json")
             Configuration file of Time-To-Live of cached objects.
-            file format must be JSON, like 'extra_ttl.json'.
-            if set empty, all objects are deleted after every -expire-interval.
+            file format must be JSON, like 'extra_ttl.json'. Each
+            object expires after the TTL of its namespace, and expired
+            objects are never served (unless stale windows are set).
+            if set empty, TTLs are zero and nothing is cached.
+            The file is reloaded when it's changed or on SIGHUP signal.
+
+      -expire:watch=duration     (default 5s)
+            Checks the expire ttl file for changes after any interval
+            time. zero disables watching (SIGHUP still reloads file).
 
       -sqlite:dsn=dsn     (default "db.sqlite3")
             SQLite path address.
@@ -191,6 +320,69 @@ OPTIONS
       -sqlite:timeout=duration     (default 1m)
             SQLite connecting timeout.
 
+      -sqlite:rows=int     (default 0)
+            Maximum number of objects in SQLite database. zero means
+            unlimited. it's a soft limit: it's checked after every 64
+            inserts, so the database may exceed it by up to 63 objects.
+
+      -sqlite:size=bytes     (default 0)
+            Maximum size of SQLite database in bytes. zero means
+            unlimited. it's a soft limit like -sqlite:rows.
+
+      -sqlite:eviction=policy     (default "lru")
+            Which objects are evicted when SQLite database is full
+            (see -sqlite:rows and -sqlite:size): "lru" evicts least
+            recently accessed objects, "expires" evicts objects which
+            expire sooner. Expired objects are always evicted first.
+
+      -sqlite:journal=mode     (default "")
+            SQLite journal mode: "delete", "truncate", "persist",
+            "memory", "wal" or "off". if empty, the journal mode of
+            database is kept (SQLite default is "delete"). "wal" lets
+            readers and writer work concurrently, and keeps '-wal' and
+            '-shm' files next to the database.
+
+      -sqlite:sync=level     (default "")
+            SQLite synchronous level: "off", "normal", "full" or
+            "extra". if empty, SQLite default ("full") is used.
+            "normal" is safe with "wal" journal mode, but the last
+            commits may be lost on system crash.
+
+      -sqlite:busy-timeout=duration     (default 5s)
+            Maximum duration which a SQLite connection waits for the
+            locked database before failing with 'database is locked'.
+
+      -sqlite:max-open=int     (default 0)
+            Maximum number of open SQLite connections. zero means
+            unlimited.
+
+      -sqlite:max-idle=int     (default 2)
+            Maximum number of idle SQLite connections. negative means
+            no idle connections.
+
+      -memory:entries=int     (default 10000)
+            Maximum number of objects in memory cache. zero means
+            unlimited.
+
+      -memory:size=bytes     (default 67108864)
+            Maximum size of objects in memory cache in bytes. zero
+            means unlimited.
+
+      -cache:compression=codec     (default "none")
+            Compresses cached objects by codec: "none", "deflate",
+            "gzip", "brotli" or "zstd". Small objects are stored
+            uncompressed. The codec is recorded per object, so
+            changing it doesn't invalidate the cache.
+
+      -cache:encodings=codecs     (default "")
+            Comma-separated codecs ("gzip", "brotli" or "zstd") which
+            compressed variants of cached objects are stored by, e.g.
+            "gzip,brotli". Cached objects are sent compressed to clients
+            which accept them ('Accept-Encoding' header), without
+            compressing them again. it's opt-in: by default (empty) no
+            variant is stored and objects are always sent uncompressed,
+            since every insert compresses the object once per codec.
+
   *API Client
       -client-timeout:read=duration     (default 20s)
             Maximum duration for full response reading (including body)
@@ -198,6 +390,62 @@ OPTIONS
       -client-timeout:write=duration     (default 20s)
             Maximum duration for full request writing (including body).
 
+      -client:url=url     (default "")
+            Base URL of upstream API, e.g. "http://127.0.0.1:8000".
+            It can be a mirror, a caching proxy or a mock server. if
+            not set, the original football API is used.
+
+      -client:prefix=path     (default "")
+            Path prefix which is added before the paths of upstream
+            API, e.g. "/mirror".
+
+      -client:attempts=number     (default 1)
+            Maximum attempts of each upstream request (including the
+            first one), e.g. 3. Timeouts, connection errors and retry
+            status codes are retried. 1 disables retrying.
+
+      -client:backoff=duration     (default 100ms)
+            Waiting time before first retry; it's doubled before each
+            next retry.
+
+      -client:backoff-cap=duration     (default 2s)
+            Maximum waiting time before a retry.
+
+      -client:jitter=fraction     (default 0.5)
+            Fraction of waiting time which is randomized (0 to 1), so
+            retries of concurrent requests are spread.
+
+      -client:retry-codes=codes     (default "502,503,504")
+            Comma-separated status codes of upstream which are retried.
+
+      -client:breaker=number     (default 0)
+            Consecutive failures of an upstream endpoint family (search,
+            match, competition or transfers) which open its circuit
+            breaker, e.g. 5; requests of an open family fail fast with
+            503 status code. zero disables circuit breakers.
+
+      -client:breaker-timeout=duration     (default 30s)
+            Duration that a circuit breaker is open; then a probe
+            request is sent, which closes the breaker if succeeds.
+
+      -client:rate=number     (default 0)
+            Maximum requests per second which are sent to upstream
+            (including retries), e.g. 20, to avoid being banned. zero
+            means unlimited.
+
+      -client:family-rate=rates     (default "")
+            Comma-separated requests per second of endpoint families,
+            e.g. "search=2,match=5". families are search, match,
+            competition and transfers.
+
+      -client:max-inflight=number     (default 0)
+            Maximum concurrent requests to upstream, e.g. 32. zero
+            means unlimited.
+
+      -client:max-wait=duration     (default 5s)
+            Maximum waiting time of a request for rate limits; then
+            it fails with 429 status code.
+
   *Logging
       -v=[0-4]     (default 1)
             Logging verbose level.
@@ -217,8 +465,44 @@ OPTIONS
       -log:speed
             Show server handlers ping speed. (needs -v 3 or 4)
 
+  *Prefetch
+      -prefetch:interval=duration     (default 10m)
+            Fills cache with hot objects (list of competitions, regions
+            of transfers, today's matches, and standing table, weeks and
+            current week matches of default competitions) at startup and
+            after any interval time. Fresh objects aren't fetched again.
+            zero disables prefetching.
+
+      -prefetch:targets=names     (default "")
+            Comma-separated keys of objects which are prefetched:
+            COMPETITIONS_LIST, TRANSFERS_REGIONS, MATCHES_BY_DATE,
+            COMPETITION_STANDING_TABLE, COMPETITION_WEEKS and
+            MATCHES_BY_WEEKNUMBER. if set empty, all are prefetched.
+
+      -prefetch:rate=float     (default 2)
+            Maximum requests of prefetcher to original API per second.
+            zero means unlimited.
+
+  *Admin
+      -admin:token=token     (default "")
+            Token of admin URLs (/admin/...). The token is passed by
+            'Authorization: Bearer <token>' header. If empty, admin URLs
+            are disabled.
+
   *Other
       -version  Print version and exit.
 
       -urls  Print URLs and exit.
+
+COMMANDS
+  cache export <file>
+        Writes all cached objects (which aren't expired) into file as
+        JSON lines, and exits. The file is compressed by gzip or zstd
+        if its name ends with ".gz" or ".zst". Cache options (-cache,
+        -sqlite:*, ...) select the cache which is exported.
+
+  cache import <file>
+        Inserts cached objects of file which is written by 'cache
+        export' into cache, and exits. Expired objects and objects
+        which are already in cache are skipped.
 `
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Admin URLs are registered only if ServeMux.AdminToken is set.
+var AdminURLs = [][2]interface{}{
+	{"/admin/cache/keys", adminCacheKeys},     // ns
+	{"/admin/cache/value", adminCacheValue},   // ns, key
+	{"/admin/cache/delete", adminCacheDelete}, // ns, key (POST or DELETE)
+	{"/admin/cache/sweep", adminCacheSweep},   // - (POST or DELETE)
+}
+
+// authorized reports whether the request has admin token in 'Authorization: Bearer <token>'
+// header. The token isn't accepted in query parameters, so it isn't written in access logs.
+func (m *ServeMux) authorized(ctx *fasthttp.RequestCtx) bool {
+	if m.AdminToken == "" {
+		return false
+	}
+
+	token := string(ctx.Request.Header.Peek("Authorization"))
+	if !strings.HasPrefix(token, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token[len("Bearer "):]), []byte(m.AdminToken)) == 1
+}
+
+// allowMethod reports whether the request method is POST or DELETE; otherwise writes
+// 405 status code. It's used by the handlers which change the cache.
+func allowMethod(ctx *fasthttp.RequestCtx) bool {
+	if ctx.IsPost() || ctx.IsDelete() {
+		return true
+	}
+
+	ctx.Response.Header.Set("Allow", "POST, DELETE")
+	writeError(ctx, &api.StatusCodeError{Code: fasthttp.StatusMethodNotAllowed, Msg: "method not allowed"})
+	return false
+}
+
+func writeError(ctx *fasthttp.RequestCtx, err error) {
+	i, b, _ := api.ErrToBytes(err)
+	ctx.SetStatusCode(i)
+	ctx.SetBody(b)
+}
+
+// namespaceArg parses 'ns' query parameter; returns EMPTY_APIKEY if it's optional and isn't
+// specified. An empty or unknown namespace is rejected.
+func namespaceArg(ctx *fasthttp.RequestCtx, optional bool) (cache.APICacheKey, bool) {
+	var name string
+
+	err := queryArgsParser(
+		ctx.QueryArgs(),
+		[]queryConfig{
+			{Name: "ns", Optional: optional, Object: &name},
+		},
+	)
+	if err != nil {
+		writeError(ctx, err)
+		return cache.EMPTY_APIKEY, false
+	}
+
+	if name == "" {
+		if optional && !ctx.QueryArgs().Has("ns") {
+			return cache.EMPTY_APIKEY, true
+		}
+
+		writeError(ctx, &api.StatusCodeError{Code: 400, Msg: "'ns' parameter is empty."})
+		return cache.EMPTY_APIKEY, false
+	}
+
+	apikey, ok := cache.APICacheKeyByName(name)
+	if !ok {
+		writeError(ctx, &api.StatusCodeError{Code: 400, Msg: "unknown namespace: '" + name + "'"})
+		return cache.EMPTY_APIKEY, false
+	}
+
+	return apikey, true
+}
+
+func adminCacheKeys(ctx *fasthttp.RequestCtx, _ *api.Session, cacheObject *cache.Cache) error {
+	ctx.SetContentType("application/json; charset=utf-8")
+
+	apikey, ok := namespaceArg(ctx, true)
+	if !ok {
+		return nil
+	}
+
+	names := []string{}
+	if apikey.Key == "" {
+		names = cache.APICacheKeyNames()
+	} else {
+		names = append(names, string(ctx.QueryArgs().Peek("ns")))
+	}
+
+	namespaces := make(map[string][]cache.KeyInfo, len(names))
+
+	for _, name := range names {
+		apikey, _ = cache.APICacheKeyByName(name)
+
+		keys, err := cacheObject.Keys(apikey)
+		if err != nil {
+			writeError(ctx, err)
+			return err
+		}
+
+		namespaces[name] = keys
+	}
+
+	data, _ := api.ToBytes(map[string]interface{}{"code": 200, "namespaces": namespaces})
+
+	ctx.SetStatusCode(200)
+	ctx.SetBody(data)
+	return nil
+}
+
+func adminCacheValue(ctx *fasthttp.RequestCtx, _ *api.Session, cacheObject *cache.Cache) error {
+	ctx.SetContentType("application/json; charset=utf-8")
+
+	apikey, ok := namespaceArg(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	key := string(ctx.QueryArgs().Peek("key"))
+
+	value, expiresAt, err := cacheObject.SelectRaw(apikey, key)
+	if err != nil {
+		writeError(ctx, err)
+		return err
+	}
+
+	if value == nil {
+		writeError(ctx, &api.StatusCodeError{Code: 404, Msg: "key not found"})
+		return nil
+	}
+
+	ctx.Response.Header.Set("X-Expires-At", strconv.FormatInt(expiresAt, 10))
+	ctx.SetStatusCode(200)
+	ctx.SetBody(value)
+	return nil
+}
+
+// Deletes a key, or all keys of namespace if 'key' parameter isn't specified.
+func adminCacheDelete(ctx *fasthttp.RequestCtx, _ *api.Session, cacheObject *cache.Cache) error {
+	ctx.SetContentType("application/json; charset=utf-8")
+
+	if !allowMethod(ctx) {
+		return nil
+	}
+
+	apikey, ok := namespaceArg(ctx, false)
+	if !ok {
+		return nil
+	}
+
+	var deleted int64
+	var err error
+
+	if ctx.QueryArgs().Has("key") {
+		var result bool
+		result, err = cacheObject.Delete(apikey, string(ctx.QueryArgs().Peek("key")))
+		if result {
+			deleted = 1
+		}
+	} else {
+		deleted, err = cacheObject.DeleteAll(apikey)
+	}
+
+	if err != nil {
+		writeError(ctx, err)
+		return err
+	}
+
+	data, _ := api.ToBytes(map[string]interface{}{"code": 200, "deleted": deleted})
+
+	ctx.SetStatusCode(200)
+	ctx.SetBody(data)
+	return nil
+}
+
+// Deletes expired values immediately.
+func adminCacheSweep(ctx *fasthttp.RequestCtx, _ *api.Session, cacheObject *cache.Cache) error {
+	ctx.SetContentType("application/json; charset=utf-8")
+
+	if !allowMethod(ctx) {
+		return nil
+	}
+
+	deleted, err := cacheObject.DeleteExpired()
+	if err != nil {
+		writeError(ctx, err)
+		return err
+	}
+
+	data, _ := api.ToBytes(map[string]interface{}{"code": 200, "deleted": deleted})
+
+	ctx.SetStatusCode(200)
+	ctx.SetBody(data)
+	return nil
+}
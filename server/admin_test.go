@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+	"github.com/awolverp/kickcore/server"
+
+	"github.com/valyala/fasthttp"
+)
+
+const adminToken = "secret"
+
+func newAdminMux(t *testing.T) *server.ServeMux {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	cache.MATCH_INFO.SetTTL(cache.TTLConfig{ExtraTTL: 60})
+	cache.COMPETITIONS_LIST.SetTTL(cache.TTLConfig{ExtraTTL: 60})
+
+	c.Insert(cache.MATCH_INFO, "1", []byte("{}"))
+	c.Insert(cache.COMPETITIONS_LIST, "", []byte("[]"))
+
+	mux := &server.ServeMux{Cache: c, AdminToken: adminToken}
+	mux.Init()
+	return mux
+}
+
+func adminRequest(mux *server.ServeMux, method, uri, token string) *fasthttp.RequestCtx {
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if token != "" {
+		ctx.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	mux.HandleHTTP(ctx)
+	return ctx
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	mux := newAdminMux(t)
+
+	tests := []struct {
+		name, uri, token string
+	}{
+		{"no token", "/admin/cache/keys", ""},
+		{"wrong token", "/admin/cache/keys", "wrong"},
+		{"query token", "/admin/cache/keys?token=" + adminToken, ""},
+	}
+
+	for _, test := range tests {
+		ctx := adminRequest(mux, fasthttp.MethodGet, test.uri, test.token)
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusUnauthorized {
+			t.Errorf("%s: unexpected status code: %d", test.name, code)
+		}
+	}
+
+	ctx := adminRequest(mux, fasthttp.MethodGet, "/admin/cache/keys", adminToken)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+}
+
+func TestAdminMethods(t *testing.T) {
+	mux := newAdminMux(t)
+
+	for _, uri := range []string{"/admin/cache/delete?ns=MATCH_INFO&key=1", "/admin/cache/sweep"} {
+		ctx := adminRequest(mux, fasthttp.MethodGet, uri, adminToken)
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusMethodNotAllowed {
+			t.Errorf("GET %s: unexpected status code: %d", uri, code)
+		}
+
+		for _, method := range []string{fasthttp.MethodPost, fasthttp.MethodDelete} {
+			ctx = adminRequest(mux, method, uri, adminToken)
+			if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
+				t.Errorf("%s %s: unexpected status code: %d", method, uri, code)
+			}
+		}
+	}
+
+	if n, _ := mux.Cache.Len(); n != 1 {
+		t.Fatalf("unexpected cache length: %d", n)
+	}
+}
+
+func TestAdminNamespace(t *testing.T) {
+	mux := newAdminMux(t)
+
+	for _, uri := range []string{
+		"/admin/cache/delete",
+		"/admin/cache/delete?ns=",
+		"/admin/cache/delete?ns=UNKNOWN",
+		"/admin/cache/keys?ns=",
+		"/admin/cache/value?ns=&key=1",
+	} {
+		ctx := adminRequest(mux, fasthttp.MethodPost, uri, adminToken)
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: %d", uri, code)
+		}
+	}
+
+	if n, _ := mux.Cache.Len(); n != 2 {
+		t.Fatalf("cache is modified: %d values", n)
+	}
+
+	ctx := adminRequest(mux, fasthttp.MethodPost, "/admin/cache/delete?ns=MATCH_INFO", adminToken)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+
+	if n, _ := mux.Cache.Len(); n != 1 {
+		t.Fatalf("unexpected cache length: %d", n)
+	}
+}
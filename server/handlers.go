@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,6 +21,12 @@ var URLs = [][2]interface{}{
 	// Memory usage
 	{"/stats/mem", memoryUsage}, // unit
 
+	// Cache statistics
+	{"/stats/cache", cacheStats}, // unit
+
+	// Circuit breakers of upstream API
+	{"/stats/upstream", upstreamStats}, // -
+
 	{"/api/search", searchAPI},                  // q
 	{"/api/search/advanced", advancedSearchAPI}, // q, filter, offset, limit
 
@@ -78,6 +85,49 @@ func memoryUsage(ctx *fasthttp.RequestCtx, _ *api.Session, _ *cache.Cache) error
 	return nil
 }
 
+func cacheStats(ctx *fasthttp.RequestCtx, _ *api.Session, cacheObject *cache.Cache) error {
+	var data_unit string = "B" // Bytes
+
+	err := queryArgsParser(
+		ctx.QueryArgs(),
+		[]queryConfig{
+			{Name: "unit", Optional: true, Object: &data_unit},
+		},
+	)
+	if err != nil {
+		i, b, _ := api.ErrToBytes(err)
+		ctx.SetStatusCode(i)
+		ctx.SetBody(b)
+		return nil
+	}
+
+	length, err := cacheObject.Len()
+	if err != nil {
+		return err
+	}
+
+	size, err := cacheObject.Size()
+	if err != nil {
+		return err
+	}
+
+	datamap := map[string]interface{}{
+		"code":       200,
+		"len":        length,
+		"size":       toUnit(uint64(size), &data_unit),
+		"unit":       data_unit,
+		"coalesced":  cacheObject.Coalesced(),
+		"namespaces": cacheObject.Stats(),
+	}
+
+	data, _ := api.ToBytes(datamap)
+
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetStatusCode(200)
+	ctx.Write(data)
+	return nil
+}
+
 func advancedSearchAPI(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
 	ctx.SetContentType("application/json; charset=utf-8")
 
@@ -120,7 +170,8 @@ func advancedSearchAPI(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.ADVANCED_SEARCH,
 		cache.GenerateKey(
 			query,
@@ -128,21 +179,13 @@ func advancedSearchAPI(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *
 			strconv.Itoa(int(offset)),
 			strconv.Itoa(int(limit)),
 		),
-		func() (interface{}, error) {
-			return cli.AdvancedSearch(query, uint8(filter), offset, limit)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.AdvancedSearchContext(fetchCtx, query, uint8(filter), offset, limit)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getCompetitionStandingTable(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -165,26 +208,19 @@ func getCompetitionStandingTable(ctx *fasthttp.RequestCtx, cli *api.Session, cac
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.COMPETITION_STANDING_TABLE,
 		cache.GenerateKey(
 			current_id,
 		),
-		func() (interface{}, error) {
-			return cli.GetCompetitionStandingTable(current_id)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetCompetitionStandingTableContext(fetchCtx, current_id)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getCompetitionWeeks(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -207,26 +243,19 @@ func getCompetitionWeeks(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.COMPETITION_WEEKS,
 		cache.GenerateKey(
 			current_id,
 		),
-		func() (interface{}, error) {
-			return cli.GetCompetitionWeeks(current_id)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetCompetitionWeeksContext(fetchCtx, current_id)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getCompetitionsList(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -249,26 +278,19 @@ func getCompetitionsList(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.COMPETITIONS_LIST,
 		cache.GenerateKey(
 			c_type,
 		),
-		func() (interface{}, error) {
-			return cli.GetCompetitionsList(c_type)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetCompetitionsListContext(fetchCtx, c_type)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getMatchInfo(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -291,26 +313,19 @@ func getMatchInfo(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.MATCH_INFO,
 		cache.GenerateKey(
 			match_id,
 		),
-		func() (interface{}, error) {
-			return cli.GetMatchInfo(match_id)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetMatchInfoContext(fetchCtx, match_id)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getMatchesByDate(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -337,26 +352,19 @@ func getMatchesByDate(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *c
 
 	slugs := strings.Split(slugs_q, ",")
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.MATCHES_BY_DATE,
 		cache.GenerateKey(
 			strconv.Itoa(days), slugs_q,
 		),
-		func() (interface{}, error) {
-			return cli.GetMatchesByDate(days, slugs...)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetMatchesByDateContext(fetchCtx, days, slugs...)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getMatchesByWeekNumber(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -381,26 +389,19 @@ func getMatchesByWeekNumber(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObj
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.MATCHES_BY_WEEKNUMBER,
 		cache.GenerateKey(
 			id, strconv.Itoa(weeknumber),
 		),
-		func() (interface{}, error) {
-			return cli.GetMatchesByWeekNumber(id, uint32(weeknumber))
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetMatchesByWeekNumberContext(fetchCtx, id, uint32(weeknumber))
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getTransfers(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -423,49 +424,50 @@ func getTransfers(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.TRANSFERS,
 		cache.GenerateKey(
 			sid,
 		),
-		func() (interface{}, error) {
-			return cli.GetTransfers(sid)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetTransfersContext(fetchCtx, sid)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
 
 func getTransfersRegions(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
 	ctx.SetContentType("application/json; charset=utf-8")
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.TRANSFERS_REGIONS,
 		"",
-		func() (interface{}, error) {
-			return cli.GetTransfersRegions()
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.GetTransfersRegionsContext(fetchCtx)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
+	return writeResult(ctx, data, encoding, state, err)
+}
+
+func upstreamStats(ctx *fasthttp.RequestCtx, cli *api.Session, _ *cache.Cache) error {
+	datamap := map[string]interface{}{
+		"code":     200,
+		"breakers": cli.BreakerStats(),
+		"limiter":  cli.LimiterStats(),
 	}
 
-	return err
+	data, _ := api.ToBytes(datamap)
+
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetStatusCode(200)
+	ctx.Write(data)
+	return nil
 }
 
 func searchAPI(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Cache) error {
@@ -488,22 +490,15 @@ func searchAPI(ctx *fasthttp.RequestCtx, cli *api.Session, cacheObject *cache.Ca
 		return nil
 	}
 
-	data, _, err := cacheObject.CacheFuncJSON(
+	data, encoding, state, err := cacheObject.CacheFuncJSONEncodedContext(
+		requestContext(ctx),
 		cache.SEARCH,
 		cache.GenerateKey(q),
-		func() (interface{}, error) {
-			return cli.Search(q, 0)
+		acceptEncodings(ctx),
+		func(fetchCtx context.Context) (interface{}, error) {
+			return cli.SearchContext(fetchCtx, q, 0)
 		},
 	)
 
-	if data != nil {
-		ctx.SetStatusCode(200)
-		ctx.SetBody(data)
-	} else if err != nil {
-		i, b, _ := api.ErrToBytes(err)
-		ctx.SetStatusCode(i)
-		ctx.SetBody(b)
-	}
-
-	return err
+	return writeResult(ctx, data, encoding, state, err)
 }
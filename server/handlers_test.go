@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+	"github.com/awolverp/kickcore/server"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCacheStats(t *testing.T) {
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ttl := cache.MATCH_INFO.TTL()
+	defer cache.MATCH_INFO.SetTTL(ttl)
+	cache.MATCH_INFO.SetTTL(cache.TTLConfig{ExtraTTL: 60})
+
+	f := func() (interface{}, error) { return "value", nil }
+	c.CacheFuncJSON(cache.MATCH_INFO, "1", f) // miss
+	c.CacheFuncJSON(cache.MATCH_INFO, "1", f) // hit
+
+	mux := &server.ServeMux{Cache: c}
+	mux.Init()
+
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Request.SetRequestURI("/stats/cache")
+	mux.HandleHTTP(ctx)
+
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code: %d", code)
+	}
+
+	var result struct {
+		Len        int64                  `json:"len"`
+		Unit       string                 `json:"unit"`
+		Coalesced  uint64                 `json:"coalesced"`
+		Namespaces map[string]cache.Stats `json:"namespaces"`
+	}
+
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Len != 1 || result.Unit != "b" || result.Coalesced != 0 {
+		t.Fatalf("unexpected result: %s", ctx.Response.Body())
+	}
+
+	s := result.Namespaces["MATCH_INFO"]
+	if s.Hits != 1 || s.Misses != 1 || s.Inserts != 1 || s.Fetches != 1 {
+		t.Fatalf("unexpected stats of MATCH_INFO: %+v", s)
+	}
+
+	for _, name := range cache.APICacheKeyNames() {
+		if _, ok := result.Namespaces[name]; !ok {
+			t.Errorf("namespace %s isn't reported", name)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/logging"
+)
+
+// Names of APICacheKeys which can be prefetched
+var PrefetchTargets = []string{
+	"COMPETITIONS_LIST",
+	"TRANSFERS_REGIONS",
+	"MATCHES_BY_DATE",
+	"COMPETITION_STANDING_TABLE",
+	"COMPETITION_WEEKS",
+	"MATCHES_BY_WEEKNUMBER",
+}
+
+var errPrefetcherStopped = errors.New("prefetcher is stopped")
+
+// Prefetcher - fills cache with the values of hot URLs at startup and after every interval:
+// the list of competitions, the regions of transfers, today's matches, and the standing table,
+// weeks and current week matches of each default competition.
+//
+// Values are cached by the same keys as handlers, so requests are served from cache;
+// fresh values aren't fetched again.
+type Prefetcher struct {
+	APIClient *api.Session
+	Cache     *cache.Cache
+	Logger    *logging.FileLogger
+
+	// Names of APICacheKeys which are prefetched (see PrefetchTargets); empty means all.
+	Targets []string
+
+	// Maximum number of requests to the original API per second; zero means unlimited.
+	Rate float64
+
+	cancel context.CancelFunc
+}
+
+func (p *Prefetcher) log(level int, msg string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Log(level, msg, args...)
+	}
+}
+
+// prefetchRun keeps the state of a run of prefetcher.
+type prefetchRun struct {
+	p       *Prefetcher
+	ctx     context.Context // canceled by p.Stop
+	done    chan struct{}   // closed when the run is finished
+	limiter <-chan time.Time
+	targets map[string]bool
+
+	fetched, cached, failed int
+}
+
+// wait waits for the rate limiter before calling the original API; ctx is the context of the call.
+// The calls which are made after the run is finished (e.g. refreshes of stale values in background)
+// aren't limited.
+func (r *prefetchRun) wait(ctx context.Context) error {
+	if r.limiter == nil {
+		return nil
+	}
+
+	// done and stop are checked first, since select chooses randomly between ready cases
+	select {
+	case <-r.done:
+		return nil
+	default:
+	}
+
+	if r.stopped() {
+		return errPrefetcherStopped
+	}
+
+	select {
+	case <-r.ctx.Done():
+		return errPrefetcherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.done:
+		return nil
+	case <-r.limiter:
+		return nil
+	}
+}
+
+// fetch caches the value of apikey and key by f, and returns the value.
+// If apikey isn't a target, the value is fetched only if it's required (for other targets).
+//
+// f is called only if the value is missing or expired, after the rate limiter; it may be called
+// in background (to refresh a stale value) or shared with handlers, so it must use its ctx.
+func (r *prefetchRun) fetch(
+	name, key string, required bool, f func(ctx context.Context) (interface{}, error),
+) []byte {
+	if !r.targets[name] && !required {
+		return nil
+	}
+
+	if r.stopped() {
+		r.failed++
+		return nil
+	}
+
+	apikey, _ := cache.APICacheKeyByName(name)
+
+	data, _, state, err := r.p.Cache.CacheFuncJSONEncodedContext(
+		r.ctx, apikey, key, nil,
+		func(ctx context.Context) (interface{}, error) {
+			if err := r.wait(ctx); err != nil {
+				return nil, err
+			}
+			return f(ctx)
+		},
+	)
+
+	switch {
+	case err != nil:
+		r.failed++
+		if !r.stopped() {
+			r.p.log(logging.LEVEL_WARNING, "Prefetcher: %s '%s': %s", name, key, err.Error())
+		}
+	case state == cache.STATE_MISS:
+		r.fetched++
+		r.p.log(logging.LEVEL_DEBUG, "Prefetcher: %s '%s' fetched", name, key)
+	default:
+		r.cached++
+	}
+
+	return data
+}
+
+func (r *prefetchRun) stopped() bool { return r.ctx.Err() != nil }
+
+func (p *Prefetcher) run(ctx context.Context) {
+	r := prefetchRun{p: p, ctx: ctx, done: make(chan struct{}), targets: make(map[string]bool)}
+	defer close(r.done)
+
+	if p.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / p.Rate))
+		defer ticker.Stop()
+		r.limiter = ticker.C
+	}
+
+	if len(p.Targets) == 0 {
+		for _, name := range PrefetchTargets {
+			r.targets[name] = true
+		}
+	} else {
+		for _, name := range p.Targets {
+			r.targets[name] = true
+		}
+	}
+
+	start := time.Now()
+	p.log(logging.LEVEL_INFO, "Prefetcher: running ...")
+
+	// same key as getTransfersRegions
+	r.fetch("TRANSFERS_REGIONS", "", false, func(ctx context.Context) (interface{}, error) {
+		return p.APIClient.GetTransfersRegionsContext(ctx)
+	})
+
+	// same key as getMatchesByDate (days=0)
+	r.fetch("MATCHES_BY_DATE", cache.GenerateKey(strconv.Itoa(0), ""), false, func(ctx context.Context) (interface{}, error) {
+		return p.APIClient.GetMatchesByDateContext(ctx, 0, strings.Split("", ",")...)
+	})
+
+	perCompetition := r.targets["COMPETITION_STANDING_TABLE"] || r.targets["COMPETITION_WEEKS"] ||
+		r.targets["MATCHES_BY_WEEKNUMBER"]
+
+	// same key as getCompetitionsList (type=)
+	data := r.fetch("COMPETITIONS_LIST", cache.GenerateKey(""), perCompetition, func(ctx context.Context) (interface{}, error) {
+		return p.APIClient.GetCompetitionsListContext(ctx, "")
+	})
+
+	var competitions api.CompetitionsList
+	if perCompetition && data != nil {
+		if err := json.Unmarshal(data, &competitions); err != nil {
+			p.log(logging.LEVEL_WARNING, "Prefetcher: COMPETITIONS_LIST: %s", err.Error())
+		}
+	}
+
+	for i, competition := range competitions {
+		if r.stopped() {
+			break
+		}
+
+		id := competition.Current.ID
+		if id == "" {
+			continue
+		}
+
+		p.log(
+			logging.LEVEL_INFO, "Prefetcher: competition %d/%d '%s' (%s)", i+1, len(competitions), competition.Title, id,
+		)
+
+		// same key as getCompetitionStandingTable
+		r.fetch("COMPETITION_STANDING_TABLE", cache.GenerateKey(id), false, func(ctx context.Context) (interface{}, error) {
+			return p.APIClient.GetCompetitionStandingTableContext(ctx, id)
+		})
+
+		// same key as getCompetitionWeeks
+		data := r.fetch("COMPETITION_WEEKS", cache.GenerateKey(id), r.targets["MATCHES_BY_WEEKNUMBER"], func(ctx context.Context) (interface{}, error) {
+			return p.APIClient.GetCompetitionWeeksContext(ctx, id)
+		})
+
+		if !r.targets["MATCHES_BY_WEEKNUMBER"] || data == nil {
+			continue
+		}
+
+		var weeks api.CompetitionWeeks
+		if err := json.Unmarshal(data, &weeks); err != nil || weeks.CurrentWeek.WeekNumber == 0 {
+			continue
+		}
+
+		n := weeks.CurrentWeek.WeekNumber
+
+		// same key as getMatchesByWeekNumber
+		r.fetch("MATCHES_BY_WEEKNUMBER", cache.GenerateKey(id, strconv.Itoa(int(n))), false, func(ctx context.Context) (interface{}, error) {
+			return p.APIClient.GetMatchesByWeekNumberContext(ctx, id, n)
+		})
+	}
+
+	p.log(
+		logging.LEVEL_INFO, "Prefetcher: done in %v (%d fetched, %d cached, %d failed)",
+		time.Since(start), r.fetched, r.cached, r.failed,
+	)
+}
+
+// Start runs prefetcher in background immediately, and then after every interval.
+func (p *Prefetcher) Start(interval time.Duration) error {
+	if p.cancel != nil {
+		return errors.New("prefetcher already running")
+	}
+
+	for _, name := range p.Targets {
+		var ok bool
+		for _, target := range PrefetchTargets {
+			if name == target {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return errors.New("unknown prefetch target: '" + name + "'")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go func() {
+		p.run(ctx)
+
+		ticktack := time.NewTicker(interval)
+		defer ticktack.Stop()
+
+		for {
+			select {
+			case <-ticktack.C:
+				p.run(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops prefetcher; the in-flight request of running prefetch is canceled, unless
+// handlers share it.
+func (p *Prefetcher) Stop() error {
+	if p.cancel == nil {
+		return errors.New("prefetcher isn't started")
+	}
+
+	p.log(logging.LEVEL_DEBUG, "Prefetcher: stopping ...")
+
+	p.cancel()
+	p.cancel = nil
+	return nil
+}
+
+func (p *Prefetcher) IsStarted() bool { return p.cancel != nil }
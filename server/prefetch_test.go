@@ -0,0 +1,223 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+	"github.com/awolverp/kickcore/server"
+)
+
+// newPrefetcher returns a prefetcher of TRANSFERS_REGIONS and MATCHES_BY_DATE, which its upstream
+// calls handler.
+func newPrefetcher(t *testing.T, handler http.HandlerFunc) *server.Prefetcher {
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	cli, err := api.NewSessionWithConfig(nil, &api.SessionConfig{BaseURL: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return &server.Prefetcher{
+		APIClient: cli,
+		Cache:     c,
+		Targets:   []string{"TRANSFERS_REGIONS", "MATCHES_BY_DATE"},
+		Rate:      100,
+	}
+}
+
+// waitFor waits until f returns true, or fails the test after a second.
+func waitFor(t *testing.T, msg string, f func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestPrefetchStale(t *testing.T) {
+	var requests atomic.Int64
+
+	p := newPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("{}"))
+	})
+
+	// a stale value, which is refreshed in background
+	cache.TRANSFERS_REGIONS.SetTTL(cache.TTLConfig{ExtraTTL: -1, StaleWhileRevalidate: 60})
+	p.Cache.Insert(cache.TRANSFERS_REGIONS, "", []byte(`"stale"`))
+	cache.TRANSFERS_REGIONS.SetTTL(cache.TTLConfig{ExtraTTL: 60, StaleWhileRevalidate: 60})
+
+	if err := p.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// MATCHES_BY_DATE and the refresh of TRANSFERS_REGIONS
+	waitFor(t, "stale value isn't refreshed", func() bool { return requests.Load() == 2 })
+
+	// the refresh isn't blocked by the rate limiter of finished run
+	waitFor(t, "stale value isn't replaced", func() bool {
+		data, state, _ := p.Cache.CacheFuncJSONState(cache.TRANSFERS_REGIONS, "", func() (interface{}, error) {
+			return nil, errors.New("unexpected upstream call")
+		})
+		return state == cache.STATE_HIT && string(data) != `"stale"`
+	})
+
+	p.Stop()
+
+	// the stopped prefetcher doesn't break later refreshes
+	cache.TRANSFERS_REGIONS.SetTTL(cache.TTLConfig{ExtraTTL: -1, StaleWhileRevalidate: 60})
+	p.Cache.Delete(cache.TRANSFERS_REGIONS, "")
+	p.Cache.Insert(cache.TRANSFERS_REGIONS, "", []byte(`"stale"`))
+
+	data, _, err := p.Cache.CacheFuncJSON(cache.TRANSFERS_REGIONS, "", func() (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	if err != nil || string(data) != `"stale"` {
+		t.Fatalf("unexpected result: %q, %v", data, err)
+	}
+}
+
+func TestPrefetchStop(t *testing.T) {
+	var requests atomic.Int64
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+
+	p := newPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			close(arrived)
+			<-release
+		}
+		w.Write([]byte("{}"))
+	})
+
+	cache.TRANSFERS_REGIONS.SetTTL(cache.TTLConfig{ExtraTTL: 60})
+
+	if err := p.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	<-arrived
+
+	// a handler shares the in-flight call of prefetcher
+	type result struct {
+		data []byte
+		err  error
+	}
+	shared := make(chan result)
+	go func() {
+		data, _, err := p.Cache.CacheFuncJSON(cache.TRANSFERS_REGIONS, "", func() (interface{}, error) {
+			return nil, errors.New("call isn't shared")
+		})
+		shared <- result{data, err}
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	p.Stop()
+
+	// the shared call isn't canceled by stop
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+
+	if r := <-shared; r.err != nil || r.data == nil {
+		t.Fatalf("unexpected result: %q, %v", r.data, r.err)
+	}
+
+	if n := p.Cache.Coalesced(); n != 1 {
+		t.Fatalf("call isn't shared: %d", n)
+	}
+
+	// MATCHES_BY_DATE isn't fetched after stop
+	time.Sleep(time.Millisecond * 50)
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("unexpected upstream requests: %d", n)
+	}
+}
+
+func TestPrefetchStopCancel(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	p := newPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+
+	if err := p.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	<-arrived
+	p.Stop()
+
+	// the in-flight call is canceled, so it isn't shared with later calls
+	waitFor(t, "in-flight call isn't canceled", func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+
+		_, _, _, err := p.Cache.CacheFuncJSONEncodedContext(
+			ctx, cache.TRANSFERS_REGIONS, "", nil,
+			func(context.Context) (interface{}, error) { return []int{}, nil },
+		)
+		return err == nil
+	})
+
+	select {
+	case <-arrived:
+		t.Fatal("upstream is called after stop")
+	default:
+	}
+}
+
+func TestPrefetchRate(t *testing.T) {
+	paths := make(chan string, 2)
+
+	p := newPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		w.Write([]byte("{}"))
+	})
+	p.Rate = 2
+
+	// a fresh value doesn't take a request of rate limiter
+	cache.TRANSFERS_REGIONS.SetTTL(cache.TTLConfig{ExtraTTL: 60})
+	p.Cache.Delete(cache.TRANSFERS_REGIONS, "")
+	p.Cache.Insert(cache.TRANSFERS_REGIONS, "", []byte(`"fresh"`))
+
+	start := time.Now()
+	if err := p.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	select {
+	case path := <-paths:
+		if strings.Contains(path, "transfers") {
+			t.Fatalf("fresh value is fetched: %s", path)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("upstream isn't called")
+	}
+
+	// one interval of rate limiter (500ms) for MATCHES_BY_DATE, not two
+	if elapsed := time.Since(start); elapsed > time.Millisecond*900 {
+		t.Fatalf("rate limiter is waited for the fresh value: %v", elapsed)
+	}
+}
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/awolverp/kickcore/api"
@@ -30,6 +35,16 @@ type ServeMux struct {
 	// Logs functions ping speed
 	LogSpeed bool
 
+	// Token of admin URLs; if empty, admin URLs are disabled
+	AdminToken string
+
+	// Parent of request contexts ( default context.Background() ); when it's canceled,
+	// in-flight upstream requests of handlers are canceled. See requestContext.
+	Context context.Context
+
+	// Maximum duration of upstream requests of a request; zero means no limit.
+	RequestTimeout time.Duration
+
 	handlers map[string]Handler
 }
 
@@ -37,12 +52,24 @@ func (m *ServeMux) callHandler(ctx *fasthttp.RequestCtx, h Handler) {
 	var pingspeed time.Time
 	var fname string
 
+	cli := m.APIClient
+
+	// counts upstream attempts of this request
+	var attempts atomic.Int64
+
 	if m.LogSpeed {
 		pingspeed = time.Now()
+		if cli != nil {
+			cli = cli.WithCounter(&attempts)
+		}
 	}
 
+	reqCtx, release := m.newRequestContext(ctx)
+	defer release()
+	ctx.SetUserValue(requestContextKey{}, reqCtx)
+
 	ctx.SetConnectionClose()
-	err := h(ctx, m.APIClient, m.Cache)
+	err := h(ctx, cli, m.Cache)
 
 	if m.LogSpeed && m.Logger != nil {
 		stop := time.Since(pingspeed)
@@ -52,7 +79,7 @@ func (m *ServeMux) callHandler(ctx *fasthttp.RequestCtx, h Handler) {
 			fname = strings.Split(fobj.Name(), ".")[1]
 		}
 
-		m.Logger.Log(logging.LEVEL_INFO, "(%s speed): %v", fname, stop)
+		m.Logger.Log(logging.LEVEL_INFO, "(%s speed): %v, upstream attempts: %d", fname, stop, attempts.Load())
 	}
 
 	if err != nil {
@@ -79,6 +106,68 @@ func (m *ServeMux) callHandler(ctx *fasthttp.RequestCtx, h Handler) {
 	}
 }
 
+// newRequestContext returns the context of ctx (see requestContext), and a function which
+// releases it after the handler has returned.
+func (m *ServeMux) newRequestContext(ctx *fasthttp.RequestCtx) (context.Context, func()) {
+	parent := m.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	var reqCtx context.Context
+	var cancel context.CancelFunc
+
+	if m.RequestTimeout > 0 {
+		reqCtx, cancel = context.WithTimeout(parent, m.RequestTimeout)
+	} else {
+		reqCtx, cancel = context.WithCancel(parent)
+	}
+
+	conn := ctx.Conn()
+	if conn == nil {
+		return reqCtx, cancel
+	}
+
+	stop := watchConn(conn, cancel)
+	return reqCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// watchConn calls cancel if the client disconnects, until stop is called.
+//
+// The request is read before the handler is called, and the connection isn't read again
+// since it's closed after the response (see callHandler), so conn is read in background
+// while the handler runs; the read is interrupted by stop.
+//
+// EOF isn't a disconnect: clients may close their side of conn after sending the request
+// (half-close) and still wait for the response, so the request is only limited by
+// RequestTimeout after it. Other errors (e.g. connection reset) cancel the request.
+func watchConn(conn net.Conn, cancel context.CancelFunc) (stop func()) {
+	// the read deadline of server (ReadTimeout) is for reading the request
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var b [1]byte
+		_, err := conn.Read(b[:])
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+
+	return func() {
+		conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
+
 func (m *ServeMux) Init() {
 	if m.handlers == nil {
 		m.handlers = make(map[string]Handler)
@@ -87,6 +176,12 @@ func (m *ServeMux) Init() {
 	for _, v := range URLs {
 		m.handlers[v[0].(string)] = v[1].(func(*fasthttp.RequestCtx, *api.Session, *cache.Cache) error)
 	}
+
+	if m.AdminToken != "" {
+		for _, v := range AdminURLs {
+			m.handlers[v[0].(string)] = v[1].(func(*fasthttp.RequestCtx, *api.Session, *cache.Cache) error)
+		}
+	}
 }
 
 func (m *ServeMux) AddHandler(path string, f Handler) {
@@ -116,6 +211,11 @@ func (m *ServeMux) HandleHTTP(ctx *fasthttp.RequestCtx) {
 		)
 	}
 
+	if strings.HasPrefix(path, "/admin/") && !m.authorized(ctx) {
+		ctx.Error(`{"code":401,"message":"Unauthorized"}`, fasthttp.StatusUnauthorized)
+		return
+	}
+
 	m.callHandler(ctx, callback)
 }
 
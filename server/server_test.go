@@ -0,0 +1,168 @@
+package server_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
+	"github.com/awolverp/kickcore/cache/memory"
+	"github.com/awolverp/kickcore/server"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveMux serves mux on a local address, and returns the address. Upstream requests of
+// handlers are sent to a server which doesn't respond until the test is finished.
+//
+// setup is called after mux.Init and before serving.
+func serveMux(t *testing.T, mux *server.ServeMux, setup ...func()) string {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(upstream.Close)
+	t.Cleanup(func() { close(release) })
+
+	cli, err := api.NewSessionWithConfig(
+		nil, &api.SessionConfig{BaseURL: upstream.URL, ReadTimeout: time.Minute, WriteTimeout: time.Minute},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewCache(memory.Connect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	mux.APIClient = cli
+	mux.Cache = c
+	mux.Init()
+
+	for _, f := range setup {
+		f()
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fasthttp.Server{Handler: mux.HandleHTTP, ReadTimeout: time.Second}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown() })
+
+	return l.Addr().String()
+}
+
+// statusCodes wraps the handler of path, and sends status codes of its responses to the returned channel.
+func statusCodes(mux *server.ServeMux, path string) (<-chan int, func()) {
+	codes := make(chan int, 1)
+
+	return codes, func() {
+		for _, v := range server.URLs {
+			if v[0].(string) != path {
+				continue
+			}
+
+			h := v[1].(func(*fasthttp.RequestCtx, *api.Session, *cache.Cache) error)
+			mux.AddHandler(path, func(ctx *fasthttp.RequestCtx, cli *api.Session, c *cache.Cache) error {
+				err := h(ctx, cli, c)
+				codes <- ctx.Response.StatusCode()
+				return err
+			})
+		}
+	}
+}
+
+func TestRequestDisconnect(t *testing.T) {
+	errs := make(chan error, 1)
+	mux := &server.ServeMux{
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) { errs <- err },
+	}
+
+	codes, setup := statusCodes(mux, "/api/match/info")
+
+	conn, err := net.Dial("tcp4", serveMux(t, mux, setup))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("GET /api/match/info?id=1 HTTP/1.1\r\nHost: kickcore\r\n\r\n"))
+
+	// the read timeout of server doesn't cancel the request
+	time.Sleep(1500 * time.Millisecond)
+
+	select {
+	case code := <-codes:
+		t.Fatalf("request is canceled before disconnect: %d", code)
+	default:
+	}
+
+	// resets the connection
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+
+	select {
+	case code := <-codes:
+		if code != 499 {
+			t.Fatalf("unexpected status code: %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request isn't canceled after disconnect")
+	}
+
+	// canceled requests aren't server errors
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	default:
+	}
+}
+
+func TestRequestHalfClose(t *testing.T) {
+	mux := &server.ServeMux{RequestTimeout: 500 * time.Millisecond}
+
+	conn, err := net.Dial("tcp4", serveMux(t, mux))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET /api/match/info?id=1 HTTP/1.1\r\nHost: kickcore\r\n\r\n"))
+
+	// the client still waits for the response
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	mux := &server.ServeMux{RequestTimeout: 100 * time.Millisecond}
+
+	resp, err := http.Get("http://" + serveMux(t, mux) + "/api/match/info?id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+}
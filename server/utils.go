@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"strings"
 
 	"github.com/awolverp/kickcore/api"
+	"github.com/awolverp/kickcore/cache"
 
 	"github.com/valyala/fasthttp"
 )
@@ -86,3 +89,87 @@ func toUnit(i uint64, unit *string) uint64 {
 
 	return i
 }
+
+// Content codings of compressed variants which are served to clients, in order of preference
+var contentEncodings = []struct {
+	Codec cache.Codec
+	Name  string
+}{
+	{cache.CODEC_BROTLI, "br"},
+	{cache.CODEC_ZSTD, "zstd"},
+	{cache.CODEC_GZIP, "gzip"},
+}
+
+// acceptEncodings returns the codecs which are accepted by client ('Accept-Encoding' header).
+func acceptEncodings(ctx *fasthttp.RequestCtx) []cache.Codec {
+	var accept []cache.Codec
+
+	for _, value := range contentEncodings {
+		if ctx.Request.Header.HasAcceptEncoding(value.Name) {
+			accept = append(accept, value.Codec)
+		}
+	}
+
+	return accept
+}
+
+// requestContextKey is the user value key of the request context (see requestContext).
+type requestContextKey struct{}
+
+// requestContext returns the context of ctx, which is set by ServeMux; it's canceled when the
+// client disconnects, the request times out (see ServeMux.RequestTimeout) or the handler returns.
+//
+// Upstream requests which are shared by cache must not use it (see cache.Cache.CacheFuncJSONEncodedContext).
+func requestContext(ctx *fasthttp.RequestCtx) context.Context {
+	if reqCtx, ok := ctx.UserValue(requestContextKey{}).(context.Context); ok {
+		return reqCtx
+	}
+	return context.Background()
+}
+
+// Status code of requests which are canceled by client (like nginx); it isn't logged as error.
+const statusClientClosedRequest = 499
+
+// writeResult writes the result of cache.CacheFuncJSONEncoded to response.
+//
+// The state of data is written in 'X-Cache-Status' header, and its codec in
+// 'Content-Encoding' header. 4xx errors are written with their status code and are not returned;
+// neither are timeouts of request, which are written with 504 status code.
+func writeResult(ctx *fasthttp.RequestCtx, data []byte, encoding cache.Codec, state cache.State, err error) error {
+	if data != nil {
+		ctx.Response.Header.Set("X-Cache-Status", state.String())
+		ctx.Response.Header.Set("Vary", "Accept-Encoding")
+
+		for _, value := range contentEncodings {
+			if value.Codec == encoding {
+				ctx.Response.Header.Set("Content-Encoding", value.Name)
+				break
+			}
+		}
+
+		ctx.SetStatusCode(200)
+		ctx.SetBody(data)
+	} else if err != nil {
+		switch {
+		// the request timed out (see ServeMux.RequestTimeout)
+		case errors.Is(err, context.DeadlineExceeded):
+			err = &api.StatusCodeError{Code: fasthttp.StatusGatewayTimeout, Msg: "upstream timed out"}
+
+		// the client has disconnected (or server is shutting down), so nobody reads the response
+		case errors.Is(err, context.Canceled):
+			err = &api.StatusCodeError{Code: statusClientClosedRequest, Msg: "client closed request"}
+		}
+
+		i, b, _ := api.ErrToBytes(err)
+		ctx.Response.Header.Set("X-Cache-Status", state.String())
+		ctx.SetStatusCode(i)
+		ctx.SetBody(b)
+
+		// 503 means upstream is unavailable (e.g. its circuit breaker is open), and 504 means it's too slow
+		if (i >= 400 && i < 500) || i == fasthttp.StatusServiceUnavailable || i == fasthttp.StatusGatewayTimeout {
+			return nil
+		}
+	}
+
+	return err
+}